@@ -0,0 +1,164 @@
+// Package cache keeps the results of slow lookups between invocations. Values live in
+// a small in-memory LRU in front of an optional external backend, a directory or a
+// Redis compatible server, so warm and cold serverless instances can share them.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend stores values by key until their time to live runs out
+type Backend interface {
+	// Get returns the value and whether it was found
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// DefaultMaxEntries is how many values the in-memory layer holds
+const DefaultMaxEntries = 1000
+
+// refillTTL is how long a value read from the backend is kept in memory. The backend
+// doesn't say how long the value has left so this is kept short.
+const refillTTL = time.Minute
+
+// Cache is an in-memory LRU in front of an optional external backend. Backend errors
+// are logged and treated as misses so a broken cache never fails a lookup. A nil
+// Cache caches nothing.
+type Cache struct {
+	local  *Memory
+	remote Backend
+}
+
+// New returns a cache with the local layer in front of remote, remote may be nil
+func New(local *Memory, remote Backend) *Cache {
+	return &Cache{local: local, remote: remote}
+}
+
+// Key builds a key under namespace from parts that may be secret, like a caller's
+// token. The parts are hashed so they never reach an external backend.
+func Key(namespace string, parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return namespace + ":" + hex.EncodeToString(hash[:])
+}
+
+// Get returns the value for key, filling the local layer from the backend
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if value, ok, _ := c.local.Get(key); ok {
+		return value, true
+	}
+	if c.remote == nil {
+		return nil, false
+	}
+	value, ok, err := c.remote.Get(key)
+	if err != nil {
+		log.Printf("cache get %s failed: %s", key, err.Error())
+		return nil, false
+	}
+	if ok {
+		c.local.Set(key, value, refillTTL)
+	}
+	return value, ok
+}
+
+// Set stores the value in both layers
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.local.Set(key, value, ttl)
+	if c.remote == nil {
+		return
+	}
+	if err := c.remote.Set(key, value, ttl); err != nil {
+		log.Printf("cache set %s failed: %s", key, err.Error())
+	}
+}
+
+// Delete removes the value from both layers
+func (c *Cache) Delete(key string) {
+	if c == nil {
+		return
+	}
+	c.local.Delete(key)
+	if c.remote == nil {
+		return
+	}
+	if err := c.remote.Delete(key); err != nil {
+		log.Printf("cache delete %s failed: %s", key, err.Error())
+	}
+}
+
+// GetJSON decodes the value for key into v, it reports false on a miss or a value
+// that doesn't decode
+func (c *Cache) GetJSON(key string, v interface{}) bool {
+	value, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(value, v) == nil
+}
+
+// SetJSON stores v encoded as JSON
+func (c *Cache) SetJSON(key string, v interface{}, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("cache set %s failed: %s", key, err.Error())
+		return
+	}
+	c.Set(key, value, ttl)
+}
+
+// Open returns the backend for a URL, nil when the URL is blank or memory:
+//
+//	file:///tmp/nebo-cache
+//	redis://:password@localhost:6379/0
+func Open(rawurl string) (Backend, error) {
+	if rawurl == "" || rawurl == "memory:" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return NewFile(u.Path)
+	case "redis":
+		return NewRedis(u)
+	}
+	return nil, fmt.Errorf("unsupported cache url scheme %q", u.Scheme)
+}
+
+var (
+	sharedOnce sync.Once
+	shared     *Cache
+)
+
+// Shared returns the cache used by every lookup in the process. The backend is opened
+// from rawurl the first time, when it can't be opened only the memory layer is used.
+func Shared(rawurl string) *Cache {
+	sharedOnce.Do(func() {
+		remote, err := Open(rawurl)
+		if err != nil {
+			log.Printf("cache backend unavailable, using memory only: %s", err.Error())
+			remote = nil
+		}
+		shared = New(NewMemory(DefaultMaxEntries), remote)
+	})
+	return shared
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestMemoryExpires(t *testing.T) {
+	c := &clock{now: time.Unix(1600000000, 0)}
+	m := NewMemory(10)
+	m.now = c.Now
+
+	m.Set("a", []byte("1"), time.Minute)
+	value, ok, _ := m.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "1", string(value))
+
+	c.now = c.now.Add(time.Minute)
+	_, ok, _ = m.Get("a")
+	require.False(t, ok)
+	require.Equal(t, 0, m.Len())
+}
+
+func TestMemoryDropsLeastRecentlyUsed(t *testing.T) {
+	m := NewMemory(2)
+	m.Set("a", []byte("1"), time.Minute)
+	m.Set("b", []byte("2"), time.Minute)
+	m.Get("a")
+	m.Set("c", []byte("3"), time.Minute)
+
+	_, ok, _ := m.Get("b")
+	require.False(t, ok)
+	_, ok, _ = m.Get("a")
+	require.True(t, ok)
+	_, ok, _ = m.Get("c")
+	require.True(t, ok)
+}
+
+func TestCacheFillsMemoryFromBackend(t *testing.T) {
+	remote := NewMemory(10)
+	remote.Set("a", []byte("1"), time.Hour)
+	local := NewMemory(10)
+	c := New(local, remote)
+
+	value, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "1", string(value))
+	_, ok, _ = local.Get("a")
+	require.True(t, ok)
+
+	c.SetJSON("b", map[string]int{"n": 2}, time.Hour)
+	_, ok, _ = remote.Get("b")
+	require.True(t, ok)
+	decoded := map[string]int{}
+	require.True(t, c.GetJSON("b", &decoded))
+	require.Equal(t, 2, decoded["n"])
+
+	c.Delete("b")
+	_, ok = c.Get("b")
+	require.False(t, ok)
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	c.Set("a", []byte("1"), time.Hour)
+	_, ok := c.Get("a")
+	require.False(t, ok)
+}
+
+func TestKeyHidesParts(t *testing.T) {
+	key := Key("google", "secret-token", "https://example.com")
+	require.True(t, strings.HasPrefix(key, "google:"))
+	require.NotContains(t, key, "secret-token")
+	require.NotEqual(t, key, Key("google", "other-token", "https://example.com"))
+}
+
+func TestOpen(t *testing.T) {
+	backend, err := Open("")
+	require.NoError(t, err)
+	require.Nil(t, backend)
+
+	backend, err = Open("redis://:pw@cache.internal/2")
+	require.NoError(t, err)
+	require.Equal(t, &Redis{Addr: "cache.internal:6379", Password: "pw", DB: 2, Timeout: 2 * time.Second}, backend)
+
+	_, err = Open("memcached://cache.internal")
+	require.Error(t, err)
+}
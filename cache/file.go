@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a backend that keeps each value in its own file in a directory, it suits
+// a single host or a shared volume
+type File struct {
+	dir string
+	now func() time.Time
+}
+
+type fileEntry struct {
+	Expires time.Time `json:"expires"`
+	Value   []byte    `json:"value"`
+}
+
+// NewFile returns a backend storing values in dir, creating it if needed
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir, now: time.Now}, nil
+}
+
+func (f *File) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(hash[:]))
+}
+
+func (f *File) Get(key string) ([]byte, bool, error) {
+	data, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	entry := &fileEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, false, err
+	}
+	if !f.now().Before(entry.Expires) {
+		return nil, false, f.Delete(key)
+	}
+	return entry.Value, true, nil
+}
+
+// Set writes to a temporary file and renames it so readers never see half a value
+func (f *File) Set(key string, value []byte, ttl time.Duration) error {
+	data, err := json.Marshal(&fileEntry{Expires: f.now().Add(ttl), Value: value})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+func (f *File) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
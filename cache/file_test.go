@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nebo-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &clock{now: time.Unix(1600000000, 0)}
+	f, err := NewFile(dir)
+	require.NoError(t, err)
+	f.now = c.Now
+
+	_, ok, err := f.Get("a")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, f.Set("a", []byte("1"), time.Minute))
+	value, ok, err := f.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "1", string(value))
+
+	c.now = c.now.Add(time.Minute)
+	_, ok, err = f.Get("a")
+	require.NoError(t, err)
+	require.False(t, ok)
+	files, _ := ioutil.ReadDir(dir)
+	require.Empty(t, files)
+}
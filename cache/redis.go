@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis is a backend for a Redis compatible server. It speaks just enough of the
+// protocol for GET, SET and DEL, opening a connection per command as a serverless
+// instance can be frozen between requests.
+type Redis struct {
+	Addr     string
+	Password string
+	DB       int
+	Timeout  time.Duration
+}
+
+// NewRedis returns a backend for a redis://:password@host:port/db URL
+func NewRedis(u *url.URL) (*Redis, error) {
+	r := &Redis{Addr: u.Host, Timeout: 2 * time.Second}
+	if u.Port() == "" {
+		r.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("bad redis database %q", db)
+		}
+		r.DB = n
+	}
+	return r, nil
+}
+
+func (r *Redis) Get(key string) ([]byte, bool, error) {
+	reply, err := r.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return value, true, nil
+}
+
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	_, err := r.do("SET", key, string(value), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+func (r *Redis) Delete(key string) error {
+	_, err := r.do("DEL", key)
+	return err
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends the command after any AUTH and SELECT and returns the reply to it
+func (r *Redis) do(args ...string) (interface{}, error) {
+	conn, err := net.DialTimeout("tcp", r.Addr, r.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.Timeout))
+
+	commands := [][]string{}
+	if r.Password != "" {
+		commands = append(commands, []string{"AUTH", r.Password})
+	}
+	if r.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(r.DB)})
+	}
+	commands = append(commands, args)
+
+	writer := bufio.NewWriter(conn)
+	for _, command := range commands {
+		writeCommand(writer, command)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	var reply interface{}
+	for range commands {
+		if reply, err = readReply(reader); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+func writeCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply reads one reply: a string, an integer, a bulk string as []byte, nil for a
+// missing value, or an array of replies
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
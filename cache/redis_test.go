@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a stand-in server that understands AUTH, GET, SET and DEL
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{listener: listener, values: map[string]string{}}
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		args := []string{}
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[1] == "secret" {
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "GET":
+			if value, ok := f.values[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case "SET":
+			f.values[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		case "DEL":
+			delete(f.values, args[1])
+			fmt.Fprint(conn, ":1\r\n")
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestRedis(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.listener.Close()
+	r := &Redis{Addr: server.listener.Addr().String(), Password: "secret", Timeout: time.Second}
+
+	_, ok, err := r.Get("a")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, r.Set("a", []byte("line one\r\nline two"), time.Minute))
+	value, ok, err := r.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "line one\r\nline two", string(value))
+
+	require.NoError(t, r.Delete("a"))
+	_, ok, _ = r.Get("a")
+	require.False(t, ok)
+	require.Equal(t, "AUTH", server.commands[0])
+}
+
+func TestRedisBadPassword(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.listener.Close()
+	r := &Redis{Addr: server.listener.Addr().String(), Password: "wrong", Timeout: time.Second}
+
+	_, _, err := r.Get("a")
+	require.EqualError(t, err, "redis: WRONGPASS invalid password")
+}
@@ -0,0 +1,110 @@
+// Command nebo serves every handler from one long-running process, for development
+// without `vercel dev` and ngrok and for self-hosting.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/handlers/audit"
+	"github.com/searchspring/nebo/handlers/interactions"
+	"github.com/searchspring/nebo/handlers/listSites"
+	"github.com/searchspring/nebo/handlers/nps"
+	api "github.com/searchspring/nebo/handlers/slackCommands"
+	"github.com/searchspring/nebo/handlers/slackEvents"
+	"github.com/searchspring/nebo/services/commands"
+)
+
+// shutdownTimeout is how long in flight requests get to finish once the server is stopped
+const shutdownTimeout = 30 * time.Second
+
+func main() {
+	addr := flag.String("addr", defaultAddr(), "address to listen on")
+	flag.Parse()
+
+	var env common.EnvVars
+	if err := envconfig.Process("", &env); err != nil {
+		log.Fatal(err)
+	}
+	blanks := common.FindBlankEnvVars(env)
+	if len(blanks) > 0 {
+		err := fmt.Errorf("the following env vars are blank: %s", strings.Join(blanks, ", "))
+		if env.DevMode != "development" {
+			log.Fatal(err)
+		}
+		log.Println(err.Error())
+	}
+
+	// create the DAOs before the first request rather than during it
+	commands.Shared(env)
+
+	// slow slack commands finish on goroutines after they are acknowledged
+	background := &common.Goroutines{}
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           newRouter(env, background),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err.Error())
+		}
+		close(stopped)
+	}()
+
+	log.Println("listening on " + *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+	background.Wait()
+}
+
+// defaultAddr listens on $PORT when it is set, the same port as `vercel dev` otherwise
+func defaultAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":3000"
+}
+
+// newRouter mounts every handler on the paths vercel.json routes to them
+func newRouter(env common.EnvVars, background common.Background) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/", api.NewHandler(env, background)).Methods(http.MethodPost)
+	router.Handle("/interactions", interactions.NewHandler(env, background)).Methods(http.MethodPost)
+	router.Handle("/slackEvents", slackEvents.NewHandler(env)).Methods(http.MethodPost)
+	listSites.AddRoutes(router, env)
+	audit.AddRoutes(router, env)
+	nps.AddRoutes(router, env)
+	router.Use(mux.CORSMethodMiddleware(router), logRequests)
+	return router
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Println(r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond))
+	})
+}
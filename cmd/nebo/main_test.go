@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	router := newRouter(common.EnvVars{SlackVerificationToken: "token", SlackLegacyTokenAuth: true}, &common.Goroutines{})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/slackEvents", strings.NewReader(`{"token":"token","type":"url_verification","challenge":"abc"}`))
+	r.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "abc", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/interactions", strings.NewReader("payload={}")))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
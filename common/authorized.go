@@ -0,0 +1,32 @@
+package common
+
+import (
+	"net/http"
+	"strings"
+)
+
+// authorizedRequestHeaders are the headers browsers send to the Google authorized
+// endpoints, their preflights have to allow them
+const authorizedRequestHeaders = "Content-Type, Authorization, If-None-Match, If-Modified-Since"
+
+// WithAuthorizedCheck answers CORS preflights and rejects requests whose Authorization
+// header checkUserLoggedIn refuses with a 403, the google DAO's check also enforces the
+// allowed domains. exposeHeaders are the response headers browsers may read.
+func WithAuthorizedCheck(checkUserLoggedIn func(authorizationToken string) (string, error), next http.HandlerFunc, exposeHeaders ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", authorizedRequestHeaders)
+		if len(exposeHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposeHeaders, ", "))
+		}
+		if r.Method == http.MethodOptions {
+			return
+		}
+
+		if _, err := checkUserLoggedIn(r.Header.Get("Authorization")); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
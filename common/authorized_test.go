@@ -0,0 +1,43 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAuthorizedCheck(t *testing.T) {
+	tokens := []string{}
+	check := func(token string) (string, error) {
+		tokens = append(tokens, token)
+		if token != "Bearer good" {
+			return "", errors.New("forbidden")
+		}
+		return "jane@searchspring.com", nil
+	}
+	handler := WithAuthorizedCheck(check, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}, "ETag")
+
+	// preflights are answered without a token and allow the Authorization header
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodOptions, "/audit", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+	require.Equal(t, "ETag", w.Header().Get("Access-Control-Expose-Headers"))
+	require.Empty(t, tokens)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/audit", nil)
+	r.Header.Set("Authorization", "Bearer good")
+	handler(w, r)
+	require.Equal(t, "ok", w.Body.String())
+	require.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+}
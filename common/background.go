@@ -0,0 +1,136 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BackgroundHeader marks a request a serverless function sent to itself to do the slow
+// part of a Slack request
+const BackgroundHeader = "X-Nebo-Background"
+
+// backgroundTimeout is how long background work gets, Slack's response urls accept
+// messages for much longer but a search that takes this long has failed
+const backgroundTimeout = 2 * time.Minute
+
+// selfInvokeTimeout is how long a function waits while handing work to itself, the
+// request only has to be sent
+const selfInvokeTimeout = time.Second
+
+// Background runs the slow part of a Slack request after the handler has answered,
+// Slack gives up on an answer after 3 seconds
+type Background interface {
+	// Wrap prepares requests to the handler for Run
+	Wrap(next http.Handler) http.Handler
+	// Run starts work on a context that isn't cancelled when the request ends
+	Run(r *http.Request, work func(ctx context.Context))
+}
+
+// Goroutines runs background work on a goroutine, for a long running server
+type Goroutines struct {
+	wg sync.WaitGroup
+}
+
+// Wrap returns next, goroutines don't need anything from the request
+func (g *Goroutines) Wrap(next http.Handler) http.Handler {
+	return next
+}
+
+// Run starts work on a goroutine
+func (g *Goroutines) Run(r *http.Request, work func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+		defer cancel()
+		work(ctx)
+	}()
+}
+
+// Wait blocks until the background work that has started is done
+func (g *Goroutines) Wait() {
+	g.wg.Wait()
+}
+
+// SelfInvoke hands background work to a second invocation of the same serverless
+// function, a function is frozen as soon as its handler returns so it can't run a
+// goroutine. The request is sent again with its Slack signature and BackgroundHeader,
+// the second invocation runs the same handler and does the work inline. Its answer
+// isn't waited for, the function carries on once the caller has gone.
+type SelfInvoke struct {
+	Client HTTPClient
+}
+
+// NewSelfInvoke returns a SelfInvoke that only waits for the request to be sent
+func NewSelfInvoke() *SelfInvoke {
+	return &SelfInvoke{Client: &http.Client{Timeout: selfInvokeTimeout}}
+}
+
+type bodyKey struct{}
+
+// Wrap keeps the body of the request so Run can send it again, the Slack signature
+// covers the exact bytes
+func (s *SelfInvoke) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			SendInternalServerError(w, err)
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyKey{}, body)))
+	})
+}
+
+// Run does the work inline in the second invocation, otherwise it sends the request
+// to the function again. The first invocation hangs up after selfInvokeTimeout so the
+// work gets its own context rather than the request's.
+func (s *SelfInvoke) Run(r *http.Request, work func(ctx context.Context)) {
+	if r.Header.Get(BackgroundHeader) != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+		defer cancel()
+		work(ctx)
+		return
+	}
+	if err := s.invoke(r); err != nil {
+		log.Println("handing work to a background invocation failed: " + err.Error())
+	}
+}
+
+func (s *SelfInvoke) invoke(r *http.Request) error {
+	body, ok := r.Context().Value(bodyKey{}).([]byte)
+	if !ok {
+		return errors.New("the request body wasn't kept, wrap the handler")
+	}
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+	req, err := http.NewRequest(http.MethodPost, scheme+"://"+r.Host+r.URL.RequestURI(), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	for _, header := range []string{"Content-Type", "X-Slack-Signature", "X-Slack-Request-Timestamp"} {
+		req.Header.Set(header, r.Header.Get(header))
+	}
+	req.Header.Set(BackgroundHeader, "1")
+	res, err := s.Client.Do(req)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// sent, the second invocation is still working
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfInvokeSendsTheRequestAgain(t *testing.T) {
+	invoked := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	release := make(chan struct{})
+	self := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		invoked <- r
+		bodies <- string(body)
+		// the second invocation takes longer than the first waits
+		<-release
+	}))
+	defer self.Close()
+	defer close(release)
+
+	background := &SelfInvoke{Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	ran := false
+	handler := background.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		background.Run(r, func(ctx context.Context) { ran = true })
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/interactions?x=1", strings.NewReader("payload=%7B%7D"))
+	r.Host = strings.TrimPrefix(self.URL, "http://")
+	r.Header.Set("X-Forwarded-Proto", "http")
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Signature", "v0=abc")
+	r.Header.Set("X-Slack-Request-Timestamp", "1600000000")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.False(t, ran)
+
+	again := <-invoked
+	require.Equal(t, "/interactions?x=1", again.URL.RequestURI())
+	require.Equal(t, "1", again.Header.Get(BackgroundHeader))
+	require.Equal(t, "v0=abc", again.Header.Get("X-Slack-Signature"))
+	require.Equal(t, "1600000000", again.Header.Get("X-Slack-Request-Timestamp"))
+	require.Equal(t, "payload=%7B%7D", <-bodies)
+}
+
+func TestSelfInvokeRunsInlineInTheSecondInvocation(t *testing.T) {
+	background := NewSelfInvoke()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(BackgroundHeader, "1")
+	ran := false
+	background.Run(r, func(ctx context.Context) { ran = true })
+	require.True(t, ran)
+}
+
+func TestSelfInvokeOutlivesTheCaller(t *testing.T) {
+	background := &SelfInvoke{Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	finished := make(chan error, 1)
+	handler := background.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		background.Run(r, func(ctx context.Context) {
+			// slower than the first invocation waits, it has hung up by now
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-ctx.Done():
+			}
+			finished <- ctx.Err()
+		})
+	}))
+	self := httptest.NewServer(handler)
+	defer self.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/slackCommands", strings.NewReader("text=shoes"))
+	r.Host = strings.TrimPrefix(self.URL, "http://")
+	r.Header.Set("X-Forwarded-Proto", "http")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	require.NoError(t, <-finished)
+}
+
+func TestGoroutinesDetachFromTheRequest(t *testing.T) {
+	background := &Goroutines{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var err error
+	background.Run(httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx), func(ctx context.Context) {
+		err = ctx.Err()
+	})
+	background.Wait()
+	require.NoError(t, err)
+}
@@ -0,0 +1,229 @@
+package common
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/models"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// MaxBlocks is the most blocks Slack accepts in one message
+const MaxBlocks = 50
+
+// blocksPerCard is the section, context and actions blocks of a card
+const blocksPerCard = 3
+
+// Account card button action ids
+const (
+	CopySiteIDActionID     = "copy_site_id"
+	OpenWebsiteActionID    = "open_website"
+	OpenSalesforceActionID = "open_salesforce"
+	OpenMetabaseActionID   = "open_metabase"
+	OpenSMCActionID        = "open_smc"
+)
+
+// LinkActionIDs are the buttons that open a link, Slack tells us about the click too
+var LinkActionIDs = []string{OpenWebsiteActionID, OpenSalesforceActionID, OpenMetabaseActionID, OpenSMCActionID}
+
+// AccountLinks are the URL prefixes account cards link to, the record id is appended.
+// Links with a blank prefix are left off the card.
+type AccountLinks struct {
+	// SalesforceURL is followed by the Account Id, e.g. https://searchspring.my.salesforce.com/
+	SalesforceURL string
+	// MetabaseURL is followed by the websites table id, e.g. a question with a website_id filter
+	MetabaseURL string
+	// SMCURL is followed by the site id
+	SMCURL string
+}
+
+// NewAccountLinks returns the links configured in the environment
+func NewAccountLinks(env EnvVars) AccountLinks {
+	return AccountLinks{
+		SalesforceURL: env.SfAccountURL,
+		MetabaseURL:   env.MetabaseWebsiteURL,
+		SMCURL:        env.SmcSiteURL,
+	}
+}
+
+// CardOptions change how accounts are rendered
+type CardOptions struct {
+	// ShowSources adds the system each field came from
+	ShowSources bool
+	Links       AccountLinks
+}
+
+// CardField is a labelled value in a card
+type CardField struct {
+	Label string
+	Value string
+}
+
+// Card is one result rendered as a section of fields, a context line and buttons
+type Card struct {
+	Title   string
+	Fields  []CardField
+	Context []string
+	Buttons []*slack.ButtonBlockElement
+}
+
+// Blocks returns the blocks for the card
+func (c Card) Blocks() []slack.Block {
+	fields := []*slack.TextBlockObject{}
+	for _, f := range c.Fields {
+		fields = append(fields, slack.NewTextBlockObject(slack.MarkdownType, "*"+f.Label+"*\n"+f.Value, false, false))
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, c.Title, false, false), fields, nil),
+	}
+	if len(c.Context) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, strings.Join(c.Context, "  |  "), false, false)))
+	}
+	if len(c.Buttons) > 0 {
+		elements := []slack.BlockElement{}
+		for _, button := range c.Buttons {
+			elements = append(elements, button)
+		}
+		blocks = append(blocks, slack.NewActionBlock("", elements...))
+	}
+	return blocks
+}
+
+// SetCards replaces the blocks of the message with the cards
+func SetCards(msg *slack.Msg, cards []Card) {
+	blocks := []slack.Block{}
+	for _, card := range cards {
+		blocks = append(blocks, card.Blocks()...)
+	}
+	msg.Blocks = slack.Blocks{BlockSet: blocks}
+}
+
+// CopySiteIDButton posts the site id on its own so it is easy to copy
+func CopySiteIDButton(siteID string) *slack.ButtonBlockElement {
+	return slack.NewButtonBlockElement(CopySiteIDActionID, siteID, slack.NewTextBlockObject(slack.PlainTextType, "Copy site id", false, false))
+}
+
+// OpenWebsiteButton links to the website
+func OpenWebsiteButton(website string) *slack.ButtonBlockElement {
+	return linkButton(OpenWebsiteActionID, "Open website", WebsiteURL(website))
+}
+
+func linkButton(actionID string, text string, link string) *slack.ButtonBlockElement {
+	button := slack.NewButtonBlockElement(actionID, link, slack.NewTextBlockObject(slack.PlainTextType, text, false, false))
+	button.URL = link
+	return button
+}
+
+// WebsiteURL returns a link for a website that may be missing its scheme
+func WebsiteURL(website string) string {
+	if strings.HasPrefix(website, "http://") || strings.HasPrefix(website, "https://") {
+		return website
+	}
+	return "https://" + website
+}
+
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// EscapeText escapes &, < and > in text for Slack's mrkdwn, without it a value can
+// break the message or add its own link
+func EscapeText(text string) string {
+	return mrkdwnEscaper.Replace(text)
+}
+
+// Link returns a mrkdwn link to url showing text, | separates the two so it is dropped
+// from text and encoded in url
+func Link(url string, text string) string {
+	return "<" + EscapeText(strings.ReplaceAll(url, "|", "%7C")) + "|" + EscapeText(strings.ReplaceAll(text, "|", "")) + ">"
+}
+
+func isKnown(value string) bool {
+	return value != "" && value != "unknown"
+}
+
+// AccountCard renders an account. familyMRR is passed in because accounts after the
+// first in a family don't carry it, see FormatAccountInfos.
+func AccountCard(ai *models.AccountInfo, familyMRR float64, options CardOptions) Card {
+	p := message.NewPrinter(language.English)
+	mrr := "unknown"
+	if ai.MRR > 0 {
+		mrr = p.Sprintf("$%.2f", ai.MRR)
+	}
+	loc := ai.City
+	if ai.State != "unknown" {
+		loc += ", " + ai.State
+	}
+	title := "*" + EscapeText(ai.Website) + "* (" + ai.Active + ")"
+	if isKnown(ai.Website) {
+		title = "*" + Link(WebsiteURL(ai.Website), ai.Website) + "* (" + ai.Active + ")"
+	}
+	if ai.Manager == "unknown" {
+		title = ":red_circle: " + title
+	}
+	card := Card{
+		Title: title,
+		Fields: []CardField{
+			{Label: "Rep", Value: ai.Manager},
+			{Label: "MRR", Value: mrr},
+			{Label: "Family MRR", Value: p.Sprintf("$%.2f", familyMRR)},
+			{Label: "Platform", Value: ai.Platform},
+			{Label: "Integration", Value: ai.Integration},
+			{Label: "Location", Value: loc},
+		},
+		Context: []string{"SiteId: `" + ai.SiteId + "`", "Provider: " + ai.Provider},
+		Buttons: []*slack.ButtonBlockElement{},
+	}
+	if options.ShowSources {
+		if sources := accountSources(ai); len(sources) > 0 {
+			card.Context = append(card.Context, "Sources: "+strings.Join(sources, "; "))
+		}
+	}
+	if isKnown(ai.SiteId) {
+		card.Buttons = append(card.Buttons, CopySiteIDButton(ai.SiteId))
+	}
+	if isKnown(ai.Website) {
+		card.Buttons = append(card.Buttons, OpenWebsiteButton(ai.Website))
+	}
+	links := options.Links
+	if links.SalesforceURL != "" && ai.SalesforceId != "" {
+		card.Buttons = append(card.Buttons, linkButton(OpenSalesforceActionID, "Open in Salesforce", links.SalesforceURL+url.PathEscape(ai.SalesforceId)))
+	}
+	if links.MetabaseURL != "" && ai.WebsiteId != "" {
+		card.Buttons = append(card.Buttons, linkButton(OpenMetabaseActionID, "Open in Metabase", links.MetabaseURL+url.QueryEscape(ai.WebsiteId)))
+	}
+	// only sites in the websites table are in the SMC
+	if links.SMCURL != "" && isKnown(ai.SiteId) && ai.WebsiteId != "" {
+		card.Buttons = append(card.Buttons, linkButton(OpenSMCActionID, "Open in SMC", links.SMCURL+url.QueryEscape(ai.SiteId)))
+	}
+	return card
+}
+
+// FormatAccountBlocks renders accounts as Block Kit cards. When there are too many
+// accounts for one message it falls back to attachments.
+func FormatAccountBlocks(accountInfos []*models.AccountInfo, search string, options CardOptions) *slack.Msg {
+	if len(accountInfos)*blocksPerCard > MaxBlocks-2 {
+		msg := FormatAccountInfos(accountInfos, search)
+		if options.ShowSources {
+			AddAccountSources(msg, accountInfos)
+		}
+		return msg
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         "Reps for search: " + search,
+	}
+	if len(accountInfos) == 0 {
+		msg.Text = "No results for: " + search
+	}
+	cards := []Card{}
+	globalFamilyMrr := float64(0)
+	for _, ai := range accountInfos {
+		if ai.FamilyMRR > 0 {
+			globalFamilyMrr = ai.FamilyMRR
+		}
+		cards = append(cards, AccountCard(ai, globalFamilyMrr, options))
+	}
+	SetCards(msg, cards)
+	return msg
+}
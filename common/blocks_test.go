@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAccountBlocks(t *testing.T) {
+	accounts := []*models.AccountInfo{
+		{Website: "shoes.com", SiteId: "abc123", Active: "Active", Manager: "Jane", MRR: 1200, FamilyMRR: 1500, Platform: "Shopify", City: "Denver", State: "CO", Provider: "Searchspring"},
+		{Website: "unknown", SiteId: "def456", Active: "Active", Manager: "unknown", City: "unknown", State: "unknown"},
+	}
+	msg := FormatAccountBlocks(accounts, "shoes", CardOptions{})
+	require.Equal(t, "Reps for search: shoes", msg.Text)
+	require.Empty(t, msg.Attachments)
+	require.Equal(t, 6, len(msg.Blocks.BlockSet))
+
+	section := msg.Blocks.BlockSet[0].(*slack.SectionBlock)
+	require.Equal(t, "*<https://shoes.com|shoes.com>* (Active)", section.Text.Text)
+	require.Equal(t, "*MRR*\n$1,200.00", section.Fields[1].Text)
+	require.Equal(t, "*Family MRR*\n$1,500.00", section.Fields[2].Text)
+	require.Equal(t, "*Location*\nDenver, CO", section.Fields[5].Text)
+
+	buttons := msg.Blocks.BlockSet[2].(*slack.ActionBlock).Elements.ElementSet
+	require.Equal(t, CopySiteIDActionID, buttons[0].(*slack.ButtonBlockElement).ActionID)
+	require.Equal(t, "https://shoes.com", buttons[1].(*slack.ButtonBlockElement).URL)
+
+	// the second account has no website to link to and inherits the family MRR
+	section = msg.Blocks.BlockSet[3].(*slack.SectionBlock)
+	require.Equal(t, ":red_circle: *unknown* (Active)", section.Text.Text)
+	require.Equal(t, "*Family MRR*\n$1,500.00", section.Fields[2].Text)
+	buttons = msg.Blocks.BlockSet[5].(*slack.ActionBlock).Elements.ElementSet
+	require.Equal(t, 1, len(buttons))
+
+	body, err := json.Marshal(msg)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"type":"context","elements":[{"type":"mrkdwn","text":"SiteId: `+"`abc123`"+`  |  Provider: Searchspring"}]`)
+}
+
+func TestFormatAccountBlocksFallback(t *testing.T) {
+	accounts := []*models.AccountInfo{}
+	for i := 0; i < 20; i++ {
+		accounts = append(accounts, &models.AccountInfo{Website: "shoes.com", SiteId: "abc123"})
+	}
+	msg := FormatAccountBlocks(accounts, "shoes", CardOptions{})
+	require.Equal(t, 20, len(msg.Attachments))
+	require.Empty(t, msg.Blocks.BlockSet)
+}
+
+func TestAccountCardLinks(t *testing.T) {
+	account := &models.AccountInfo{Website: "shoes.com", SiteId: "abc123", SalesforceId: "0015000000abcDE", WebsiteId: "42"}
+	links := AccountLinks{
+		SalesforceURL: "https://searchspring.my.salesforce.com/",
+		MetabaseURL:   "https://metabase.example.com/question/7?website_id=",
+		SMCURL:        "https://manage.example.com/site/",
+	}
+	buttons := AccountCard(account, 0, CardOptions{Links: links}).Buttons
+	require.Equal(t, 5, len(buttons))
+	require.Equal(t, "https://searchspring.my.salesforce.com/0015000000abcDE", buttons[2].URL)
+	require.Equal(t, "https://metabase.example.com/question/7?website_id=42", buttons[3].URL)
+	require.Equal(t, "https://manage.example.com/site/abc123", buttons[4].URL)
+
+	// no links without a base url or a record id
+	account.SalesforceId = ""
+	buttons = AccountCard(account, 0, CardOptions{Links: AccountLinks{SalesforceURL: links.SalesforceURL}}).Buttons
+	require.Equal(t, 2, len(buttons))
+}
+
+func TestAccountCardEscapesWebsite(t *testing.T) {
+	card := AccountCard(&models.AccountInfo{Website: "shoes.com/<a>|b&c", Active: "Active", Manager: "Jane"}, 0, CardOptions{})
+	require.Equal(t, "*<https://shoes.com/&lt;a&gt;%7Cb&amp;c|shoes.com/&lt;a&gt;b&amp;c>* (Active)", card.Title)
+}
@@ -2,7 +2,6 @@ package common
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"reflect"
@@ -16,17 +15,27 @@ import (
 
 type EnvVars struct {
 	DevMode                string `split_words:"true" required:"false"`
-	SlackVerificationToken string `split_words:"true" required:"false"`
+	SlackVerificationToken string `split_words:"true" required:"false" optional:"true"`
+	SlackSigningSecret     string `split_words:"true" required:"false"`
+	SlackLegacyTokenAuth   bool   `split_words:"true" required:"false"`
 	SlackOauthToken        string `split_words:"true" required:"false"`
 	SfURL                  string `split_words:"true" required:"false"`
 	SfUser                 string `split_words:"true" required:"false"`
-	SfPassword             string `split_words:"true" required:"false"`
-	SfToken                string `split_words:"true" required:"false"`
+	SfPassword             string `split_words:"true" required:"false" optional:"true"`
+	SfToken                string `split_words:"true" required:"false" optional:"true"`
+	SfClientId             string `split_words:"true" required:"false" optional:"true"`
+	SfPrivateKey           string `split_words:"true" required:"false" optional:"true"`
 	NxUser                 string `split_words:"true" required:"false"`
 	NxPassword             string `split_words:"true" required:"false"`
 	GdriveFireDocFolderID  string `split_words:"true" required:"false"`
 	MetabaseUser           string `split_words:"true" required:"false"`
 	MetabasePassword       string `split_words:"true" required:"false"`
+	SfAccountURL           string `split_words:"true" required:"false" optional:"true"`
+	MetabaseWebsiteURL     string `split_words:"true" required:"false" optional:"true"`
+	SmcSiteURL             string `split_words:"true" required:"false" optional:"true"`
+	CacheURL               string `split_words:"true" required:"false" optional:"true"`
+	GoogleAllowedDomains   string `split_words:"true" required:"false" optional:"true"`
+	GoogleClientIds        string `split_words:"true" required:"false"`
 }
 
 // Platforms is a list of platforms in salesforce
@@ -72,11 +81,15 @@ func SendInternalServerError(res http.ResponseWriter, err error) {
 	http.Error(res, err.Error(), http.StatusInternalServerError)
 }
 
+// FindBlankEnvVars returns the names of the blank env vars, optional ones are left out
 func FindBlankEnvVars(env EnvVars) []string {
 	var blanks []string
 	valueOfStruct := reflect.ValueOf(env)
 	typeOfStruct := valueOfStruct.Type()
 	for i := 0; i < valueOfStruct.NumField(); i++ {
+		if typeOfStruct.Field(i).Tag.Get("optional") == "true" {
+			continue
+		}
 		if valueOfStruct.Field(i).Interface() == "" {
 			blanks = append(blanks, typeOfStruct.Field(i).Name)
 		}
@@ -94,69 +107,13 @@ func ContainsEmptyString(vars ...string) bool {
 	return false
 }
 
-// HTTP Google Client Common Code
-
 // HTTPClient interface
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-type Client struct {
-	httpClient HTTPClient
-	cache      map[string][]byte
-}
-
-// Create a new Client
-func NewClient(client HTTPClient) *Client {
-	return &Client{
-		httpClient: client,
-		cache:      map[string][]byte{},
-	}
-}
-
-// AuthorizedGet make a secure request out to the googs
-func (c *Client) AuthorizedGet(token string, url string) ([]byte, error) {
-	return c.AuthorizedGetWithCache(token, url, true)
-}
-
-// AuthorizedGetNoCache make a secure request out to the googs and always hit the live service
-func (c *Client) AuthorizedGetNoCache(token string, url string) ([]byte, error) {
-	return c.AuthorizedGetWithCache(token, url, false)
-}
-
-// AuthorizedGetWithCache make a secure request out to the googs and possibly use a cache.
-func (c *Client) AuthorizedGetWithCache(token string, url string, useCache bool) ([]byte, error) {
-
-	if body, ok := c.cache[url]; ok && useCache {
-		return body, nil
-	}
-	token = strings.TrimSpace(token)
-	if token == "" {
-		return nil, fmt.Errorf("authorization failed - no authorization header")
-	}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("authorization", "Bearer "+token)
-	response, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed connecting to %s with error %s", url, err.Error())
-	}
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed read response: %s", err.Error())
-	}
-	if response.StatusCode < 200 || response.StatusCode > 299 {
-		log.Println("error from server", string(body))
-		return nil, fmt.Errorf("failed to reading from URL - status code: %d - error: %s", response.StatusCode, string(body))
-	}
-	c.cache[url] = body
-	return body, nil
-}
-
-// formats AccountInfo into Slack Message
+// formats AccountInfo into Slack Message, FormatAccountBlocks is preferred and falls
+// back to this when the accounts don't fit in one Block Kit message
 
 // example formatting here: https://api.slack.com/reference/messaging/attachments
 func FormatAccountInfos(accountInfos []*models.AccountInfo, search string) *slack.Msg {
@@ -204,3 +161,45 @@ func FormatAccountInfos(accountInfos []*models.AccountInfo, search string) *slac
 	}
 	return msg
 }
+
+var fieldLabels = map[string]string{
+	"Manager":   "Rep",
+	"SiteId":    "SiteId",
+	"FamilyMRR": "Family MRR",
+}
+
+// AddAccountSources appends a line to each account attachment saying which system
+// each field came from, grouped by system
+func AddAccountSources(msg *slack.Msg, accountInfos []*models.AccountInfo) {
+	for i, ai := range accountInfos {
+		if len(ai.Sources) == 0 || i >= len(msg.Attachments) {
+			continue
+		}
+		msg.Attachments[i].Text += "\nSources:\n  " + strings.Join(accountSources(ai), "\n  ")
+	}
+}
+
+// accountSources lists the fields each system supplied, e.g. "Metabase: Website, MRR"
+func accountSources(ai *models.AccountInfo) []string {
+	systems := []string{}
+	fields := map[string][]string{}
+	for _, field := range models.MergeFields {
+		system, ok := ai.Sources[field]
+		if !ok {
+			continue
+		}
+		if _, seen := fields[system]; !seen {
+			systems = append(systems, system)
+		}
+		label := field
+		if l, ok := fieldLabels[field]; ok {
+			label = l
+		}
+		fields[system] = append(fields[system], label)
+	}
+	lines := []string{}
+	for _, system := range systems {
+		lines = append(lines, system+": "+strings.Join(fields[system], ", "))
+	}
+	return lines
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBlankEnvVarsSkipsOptional(t *testing.T) {
+	blanks := FindBlankEnvVars(EnvVars{DevMode: "development"})
+	require.Contains(t, blanks, "SfUser")
+	require.NotContains(t, blanks, "SfPassword")
+	require.NotContains(t, blanks, "SfPrivateKey")
+	require.NotContains(t, blanks, "CacheURL")
+}
+
+func TestFindBlankEnvVarsSkipsRecordLinks(t *testing.T) {
+	blanks := FindBlankEnvVars(EnvVars{DevMode: "development"})
+	require.NotContains(t, blanks, "SfAccountURL")
+	require.NotContains(t, blanks, "MetabaseWebsiteURL")
+	require.NotContains(t, blanks, "SmcSiteURL")
+}
+
+func TestFindBlankEnvVarsReportsGoogleClientIds(t *testing.T) {
+	blanks := FindBlankEnvVars(EnvVars{DevMode: "development"})
+	require.Contains(t, blanks, "GoogleClientIds")
+	require.NotContains(t, blanks, "GoogleAllowedDomains")
+}
+
+func TestFindBlankEnvVarsSkipsVerificationToken(t *testing.T) {
+	blanks := FindBlankEnvVars(EnvVars{DevMode: "development"})
+	require.NotContains(t, blanks, "SlackVerificationToken")
+	require.Contains(t, blanks, "SlackSigningSecret")
+}
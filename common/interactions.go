@@ -0,0 +1,156 @@
+package common
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Interaction payload types
+const (
+	BlockActions   = "block_actions"
+	ViewSubmission = "view_submission"
+	Shortcut       = "shortcut"
+	MessageAction  = "message_action"
+)
+
+// InteractionPayload is the payload Slack posts when someone clicks a button, submits
+// a modal or runs a shortcut. The slack library we use predates modals so the parts we
+// need are declared here.
+type InteractionPayload struct {
+	Type        string              `json:"type"`
+	CallbackID  string              `json:"callback_id"`
+	TriggerID   string              `json:"trigger_id"`
+	ResponseURL string              `json:"response_url"`
+	User        InteractionUser     `json:"user"`
+	Channel     InteractionChannel  `json:"channel"`
+	Actions     []InteractionAction `json:"actions"`
+	View        *InteractionView    `json:"view"`
+}
+
+type InteractionUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+type InteractionChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// InteractionAction is a button click or menu selection in a block
+type InteractionAction struct {
+	ActionID       string `json:"action_id"`
+	BlockID        string `json:"block_id"`
+	Type           string `json:"type"`
+	Value          string `json:"value"`
+	SelectedOption struct {
+		Value string `json:"value"`
+	} `json:"selected_option"`
+}
+
+// InteractionView is a submitted modal
+type InteractionView struct {
+	ID              string `json:"id"`
+	CallbackID      string `json:"callback_id"`
+	PrivateMetadata string `json:"private_metadata"`
+	State           struct {
+		Values map[string]map[string]struct {
+			Value          string `json:"value"`
+			SelectedOption struct {
+				Value string `json:"value"`
+			} `json:"selected_option"`
+		} `json:"values"`
+	} `json:"state"`
+}
+
+// Value returns what was entered in an input of the modal
+func (v *InteractionView) Value(blockID string, actionID string) string {
+	input := v.State.Values[blockID][actionID]
+	if input.Value != "" {
+		return input.Value
+	}
+	return input.SelectedOption.Value
+}
+
+// InteractionHandler handles one kind of interaction. Slack only waits 3 seconds for a
+// response, slow handlers should reply to the payload's response url instead.
+type InteractionHandler func(w http.ResponseWriter, r *http.Request, payload *InteractionPayload)
+
+// InteractionRouter sends block actions to a handler by action id, and modal
+// submissions and shortcuts by callback id
+type InteractionRouter struct {
+	actions   map[string]InteractionHandler
+	views     map[string]InteractionHandler
+	shortcuts map[string]InteractionHandler
+}
+
+// NewInteractionRouter returns a router with no handlers
+func NewInteractionRouter() *InteractionRouter {
+	return &InteractionRouter{
+		actions:   map[string]InteractionHandler{},
+		views:     map[string]InteractionHandler{},
+		shortcuts: map[string]InteractionHandler{},
+	}
+}
+
+// Action handles block actions with the action id
+func (ir *InteractionRouter) Action(actionID string, handler InteractionHandler) {
+	ir.actions[actionID] = handler
+}
+
+// ViewSubmission handles submissions of modals with the callback id
+func (ir *InteractionRouter) ViewSubmission(callbackID string, handler InteractionHandler) {
+	ir.views[callbackID] = handler
+}
+
+// Shortcut handles global and message shortcuts with the callback id
+func (ir *InteractionRouter) Shortcut(callbackID string, handler InteractionHandler) {
+	ir.shortcuts[callbackID] = handler
+}
+
+// ServeHTTP parses the payload form field and calls the matching handler. Interactions
+// nobody handles are acknowledged so Slack doesn't show the user an error.
+func (ir *InteractionRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload := &InteractionPayload{}
+	if err := json.Unmarshal([]byte(r.PostFormValue("payload")), payload); err != nil {
+		http.Error(w, "can't read payload", http.StatusBadRequest)
+		return
+	}
+	handler := ir.route(payload)
+	if handler == nil {
+		log.Printf("no handler for %s interaction", payload.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	handler(w, r, payload)
+}
+
+func (ir *InteractionRouter) route(payload *InteractionPayload) InteractionHandler {
+	switch payload.Type {
+	case BlockActions:
+		for _, action := range payload.Actions {
+			if handler, ok := ir.actions[action.ActionID]; ok {
+				return handler
+			}
+		}
+	case ViewSubmission:
+		if payload.View != nil {
+			return ir.views[payload.View.CallbackID]
+		}
+	case Shortcut, MessageAction:
+		return ir.shortcuts[payload.CallbackID]
+	}
+	return nil
+}
+
+// Action returns the first action in the payload with the action id
+func (p *InteractionPayload) Action(actionID string) (InteractionAction, bool) {
+	for _, action := range p.Actions {
+		if action.ActionID == actionID {
+			return action, true
+		}
+	}
+	return InteractionAction{}, false
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func postPayload(router *InteractionRouter, payload string) *httptest.ResponseRecorder {
+	form := url.Values{"payload": {payload}}
+	r := httptest.NewRequest(http.MethodPost, "/interactions", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestInteractionRouter(t *testing.T) {
+	routed := ""
+	router := NewInteractionRouter()
+	router.Action("next_page", func(w http.ResponseWriter, r *http.Request, payload *InteractionPayload) {
+		action, _ := payload.Action("next_page")
+		routed = "action " + action.Value
+	})
+	router.ViewSubmission("feedback", func(w http.ResponseWriter, r *http.Request, payload *InteractionPayload) {
+		routed = "view " + payload.View.Value("comment", "comment_input")
+	})
+	router.Shortcut("find_site", func(w http.ResponseWriter, r *http.Request, payload *InteractionPayload) {
+		routed = "shortcut " + payload.TriggerID
+	})
+
+	postPayload(router, `{"type":"block_actions","actions":[{"block_id":"paging","action_id":"next_page","value":"20"}]}`)
+	require.Equal(t, "action 20", routed)
+
+	postPayload(router, `{"type":"view_submission","view":{"callback_id":"feedback","state":{"values":{"comment":{"comment_input":{"type":"plain_text_input","value":"great"}}}}}}`)
+	require.Equal(t, "view great", routed)
+
+	postPayload(router, `{"type":"shortcut","callback_id":"find_site","trigger_id":"123.456"}`)
+	require.Equal(t, "shortcut 123.456", routed)
+}
+
+func TestInteractionRouterUnhandled(t *testing.T) {
+	router := NewInteractionRouter()
+	w := postPayload(router, `{"type":"block_actions","actions":[{"action_id":"unknown"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = postPayload(router, `not json`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
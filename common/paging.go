@@ -0,0 +1,85 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nlopes/slack"
+)
+
+// PageSize is how many results are shown in one message, account cards take three
+// blocks each so a page plus its header and paging buttons stays under MaxBlocks
+const PageSize = 15
+
+// Paging button action ids
+const (
+	NextPageActionID     = "next_page"
+	PreviousPageActionID = "previous_page"
+)
+
+// Page is a page of results for a slash command. It is the value of the paging
+// buttons so the interactions handler can re-run the command for another page.
+type Page struct {
+	Command string `json:"c"`
+	Text    string `json:"t"`
+	Offset  int    `json:"o"`
+}
+
+// ParsePage reads a page from a button value
+func ParsePage(value string) (Page, error) {
+	page := Page{}
+	err := json.Unmarshal([]byte(value), &page)
+	return page, err
+}
+
+// Bounds returns the slice bounds of the page within total results
+func (p Page) Bounds(total int) (start int, end int) {
+	start = p.Offset
+	if start < 0 || start > total {
+		start = 0
+	}
+	end = start + PageSize
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// AddPaging wraps the blocks of the message in a header saying which results are
+// shown and Previous and Next page buttons. Block messages don't show the message
+// text so the header repeats it.
+func AddPaging(msg *slack.Msg, page Page, total int) {
+	start, end := page.Bounds(total)
+	header := msg.Text
+	if total > PageSize {
+		header += fmt.Sprintf("\nShowing %d-%d of %d", start+1, end, total)
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, header, false, false), nil, nil),
+	}
+	blocks = append(blocks, msg.Blocks.BlockSet...)
+
+	buttons := []slack.BlockElement{}
+	if start > 0 {
+		previous := page
+		previous.Offset = start - PageSize
+		if previous.Offset < 0 {
+			previous.Offset = 0
+		}
+		buttons = append(buttons, pageButton(PreviousPageActionID, "Previous page", previous))
+	}
+	if end < total {
+		next := page
+		next.Offset = end
+		buttons = append(buttons, pageButton(NextPageActionID, "Next page", next))
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slack.NewActionBlock("paging", buttons...))
+	}
+	msg.Blocks = slack.Blocks{BlockSet: blocks}
+}
+
+func pageButton(actionID string, text string, page Page) *slack.ButtonBlockElement {
+	value, _ := json.Marshal(page)
+	return slack.NewButtonBlockElement(actionID, string(value), slack.NewTextBlockObject(slack.PlainTextType, text, false, false))
+}
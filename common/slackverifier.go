@@ -0,0 +1,101 @@
+package common
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// SlackVerifier checks that incoming requests were sent by Slack
+type SlackVerifier struct {
+	SigningSecret     string
+	VerificationToken string
+	AllowLegacyToken  bool
+}
+
+// NewSlackVerifier returns a verifier configured from the environment
+func NewSlackVerifier(env EnvVars) *SlackVerifier {
+	return &SlackVerifier{
+		SigningSecret:     env.SlackSigningSecret,
+		VerificationToken: env.SlackVerificationToken,
+		AllowLegacyToken:  env.SlackLegacyTokenAuth,
+	}
+}
+
+// Verify checks the X-Slack-Signature header of the request, the HMAC-SHA256 of
+// "v0:timestamp:body" using the signing secret. Requests with a timestamp more than
+// five minutes old are rejected to prevent replays. The deprecated verification token
+// is only accepted when AllowLegacyToken is set. The request body is restored so
+// handlers can read it after verification.
+func (v *SlackVerifier) Verify(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	if r.Header.Get("X-Slack-Signature") == "" && v.AllowLegacyToken {
+		return v.verifyLegacyToken(r.Header.Get("Content-Type"), body)
+	}
+	if v.SigningSecret == "" {
+		return errors.New("slack signing secret is not configured")
+	}
+	sv, err := slack.NewSecretsVerifier(r.Header, v.SigningSecret)
+	if err != nil {
+		return err
+	}
+	if _, err := sv.Write(body); err != nil {
+		return err
+	}
+	return sv.Ensure()
+}
+
+// Middleware rejects any request that fails verification with a 401
+func (v *SlackVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			log.Println("slack verification failed: " + err.Error())
+			http.Error(w, "slack verification failed", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *SlackVerifier) verifyLegacyToken(contentType string, body []byte) error {
+	token := legacyToken(contentType, body)
+	if v.VerificationToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(v.VerificationToken)) != 1 {
+		return errors.New("invalid verification token")
+	}
+	return nil
+}
+
+// legacyToken pulls the verification token out of a slash command form, an
+// interactive "payload" form or a JSON event body
+func legacyToken(contentType string, body []byte) string {
+	holder := &struct {
+		Token string `json:"token"`
+	}{}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ""
+		}
+		if payload := values.Get("payload"); payload != "" {
+			json.Unmarshal([]byte(payload), holder)
+			return holder.Token
+		}
+		return values.Get("token")
+	}
+	json.Unmarshal(body, holder)
+	return holder.Token
+}
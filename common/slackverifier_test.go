@@ -0,0 +1,84 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func signedRequest(secret string, body string, timestamp time.Time) *http.Request {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestVerifyValidSignature(t *testing.T) {
+	v := &SlackVerifier{SigningSecret: "secret"}
+	r := signedRequest("secret", "command=%2Fnebo&text=shoes", time.Now())
+	require.NoError(t, v.Verify(r))
+	body, _ := ioutil.ReadAll(r.Body)
+	require.Equal(t, "command=%2Fnebo&text=shoes", string(body))
+}
+
+func TestVerifyWrongSecret(t *testing.T) {
+	v := &SlackVerifier{SigningSecret: "secret"}
+	require.Error(t, v.Verify(signedRequest("other", "text=shoes", time.Now())))
+}
+
+func TestVerifyStaleTimestamp(t *testing.T) {
+	v := &SlackVerifier{SigningSecret: "secret"}
+	require.Error(t, v.Verify(signedRequest("secret", "text=shoes", time.Now().Add(-10*time.Minute))))
+}
+
+func TestVerifyLegacyTokenRequiresOptIn(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("token=abc&text=shoes"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	v := &SlackVerifier{SigningSecret: "secret", VerificationToken: "abc"}
+	require.Error(t, v.Verify(r))
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader("token=abc&text=shoes"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	v.AllowLegacyToken = true
+	require.NoError(t, v.Verify(r))
+}
+
+func TestVerifyLegacyTokenJSON(t *testing.T) {
+	v := &SlackVerifier{VerificationToken: "abc", AllowLegacyToken: true}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"token":"abc","type":"event_callback"}`))
+	r.Header.Set("Content-Type", "application/json")
+	require.NoError(t, v.Verify(r))
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(`{"token":"nope","type":"event_callback"}`))
+	r.Header.Set("Content-Type", "application/json")
+	require.Error(t, v.Verify(r))
+}
+
+func TestMiddlewareRejectsUnsigned(t *testing.T) {
+	v := &SlackVerifier{SigningSecret: "secret"}
+	called := false
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("text=shoes")))
+	require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	require.False(t, called)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest("secret", "text=shoes", time.Now()))
+	require.True(t, called)
+}
@@ -2,36 +2,199 @@ package google
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/searchspring/nebo/cache"
 	common "github.com/searchspring/nebo/common"
 )
 
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
 type DAO interface {
 	CheckUserLoggedIn(token string) (string, error)
 }
+
 type DAOImpl struct {
-	Client *common.Client
+	Client common.HTTPClient
+	Cache  *cache.Cache
+	Policy Policy
+	now    func() time.Time
+}
+
+// TokenInfo is what Google's tokeninfo endpoint says about a token
+type TokenInfo struct {
+	Audience      string `json:"aud"`
+	AuthorizedBy  string `json:"azp"`
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	HostedDomain  string `json:"hd"`
+	Expiry        string `json:"exp"`
+}
+
+// ExpiresAt returns when the token stops being valid
+func (t *TokenInfo) ExpiresAt() (time.Time, error) {
+	seconds, err := strconv.ParseInt(t.Expiry, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("token has no expiry")
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// ErrForbidden is returned for a valid token the policy doesn't allow
+var ErrForbidden = errors.New("forbidden")
+
+// Policy decides which Google accounts may use the endpoints
+type Policy struct {
+	// Domains are the allowed Google Workspace domains
+	Domains []string
+	// Audiences are the OAuth client ids a token must be issued to, every token is
+	// refused when there are none
+	Audiences []string
+}
+
+// NewPolicy reads the comma separated GOOGLE_ALLOWED_DOMAINS and GOOGLE_CLIENT_IDS,
+// only searchspring.com is allowed when no domains are set. Without client ids a token
+// any application got for a searchspring.com user would pass, so every token is refused.
+func NewPolicy(env common.EnvVars) Policy {
+	policy := Policy{
+		Domains:   splitList(env.GoogleAllowedDomains),
+		Audiences: splitList(env.GoogleClientIds),
+	}
+	if len(policy.Domains) == 0 {
+		policy.Domains = []string{"searchspring.com"}
+	}
+	if len(policy.Audiences) == 0 {
+		// only reached in development, FindBlankEnvVars stops anything else starting
+		log.Println("WARNING: GOOGLE_CLIENT_IDS is not set, every Google token will be refused")
+	}
+	return policy
+}
+
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Check returns an error wrapping ErrForbidden when no client ids are configured, the
+// token was issued to another client or the account isn't in an allowed domain. The
+// domain of the verified email is the real check: tokeninfo only has the hosted domain
+// (hd) claim for id tokens, never for access tokens. When the claim is there it must
+// be an allowed domain too.
+func (p Policy) Check(info *TokenInfo) error {
+	if len(p.Audiences) == 0 {
+		return fmt.Errorf("%w: no Google client ids are configured", ErrForbidden)
+	}
+	if !contains(p.Audiences, info.Audience) && !contains(p.Audiences, info.AuthorizedBy) {
+		return fmt.Errorf("%w: token was issued to another application", ErrForbidden)
+	}
+	if info.EmailVerified != "true" {
+		return fmt.Errorf("%w: email address is not verified", ErrForbidden)
+	}
+	domain := info.Email[strings.LastIndex(info.Email, "@")+1:]
+	if !contains(p.Domains, domain) || info.HostedDomain != "" && !contains(p.Domains, info.HostedDomain) {
+		return fmt.Errorf("%w: must have a %s email address to use this system", ErrForbidden, strings.Join(p.Domains, " or "))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
 }
 
-func NewDAO(client *common.Client) DAO {
+// NewDAO returns a DAO that verifies tokens with Google, verified tokens are kept in c
+// until they expire
+func NewDAO(client common.HTTPClient, c *cache.Cache, policy Policy) DAO {
 	return &DAOImpl{
 		Client: client,
+		Cache:  c,
+		Policy: policy,
+		now:    time.Now,
 	}
 }
 
+// CheckUserLoggedIn verifies the token from an Authorization header and returns the
+// email address it belongs to
 func (d *DAOImpl) CheckUserLoggedIn(token string) (string, error) {
-	body, err := d.Client.AuthorizedGet(token, "https://www.googleapis.com/oauth2/v2/userinfo?access_token="+token)
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	if token == "" {
+		return "", fmt.Errorf("authorization failed - no authorization header")
+	}
+
+	key := cache.Key("google-token", token)
+	info := &TokenInfo{}
+	cached := d.Cache.GetJSON(key, info)
+	if !cached {
+		var err error
+		if info, err = d.tokenInfo(token); err != nil {
+			return "", err
+		}
+	}
+	expires, err := info.ExpiresAt()
 	if err != nil {
 		return "", err
 	}
-	type emailHolder struct {
-		Email string `json:"email"`
+	ttl := expires.Sub(d.now())
+	if ttl <= 0 {
+		d.Cache.Delete(key)
+		return "", fmt.Errorf("authorization failed - token expired")
 	}
-	user := &emailHolder{}
-	
-	err = json.Unmarshal(body, user)
-	if err != nil {
+	if err := d.Policy.Check(info); err != nil {
 		return "", err
 	}
-	return user.Email, nil
-} 
+	if !cached {
+		d.Cache.SetJSON(key, info, ttl)
+	}
+	return info.Email, nil
+}
+
+// tokenInfo asks Google about an access token or, when it is a JWT, an id token. The
+// token is posted rather than put in the URL so it doesn't end up in logs.
+func (d *DAOImpl) tokenInfo(token string) (*TokenInfo, error) {
+	param := "access_token"
+	if strings.Count(token, ".") == 2 {
+		param = "id_token"
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenInfoURL, strings.NewReader(url.Values{param: {token}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := d.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to %s with error %s", tokenInfoURL, err.Error())
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed read response: %s", err.Error())
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("authorization failed - invalid token")
+	}
+	info := &TokenInfo{}
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
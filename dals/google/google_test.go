@@ -0,0 +1,95 @@
+package google
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/searchspring/nebo/cache"
+	"github.com/searchspring/nebo/common"
+	"github.com/stretchr/testify/require"
+)
+
+type tokenInfoClient struct {
+	status int
+	body   string
+	calls  int
+	form   string
+}
+
+func (c *tokenInfoClient) Do(req *http.Request) (*http.Response, error) {
+	c.calls++
+	form, _ := ioutil.ReadAll(req.Body)
+	c.form = string(form)
+	return &http.Response{StatusCode: c.status, Body: ioutil.NopCloser(bytes.NewBufferString(c.body))}, nil
+}
+
+var now = time.Unix(1600000000, 0)
+
+func newTestDAO(client *tokenInfoClient, policy Policy) *DAOImpl {
+	return &DAOImpl{
+		Client: client,
+		Cache:  cache.New(cache.NewMemory(10), nil),
+		Policy: policy,
+		now:    func() time.Time { return now },
+	}
+}
+
+func TestCheckUserLoggedIn(t *testing.T) {
+	client := &tokenInfoClient{status: 200, body: `{"aud":"client-1","email":"jane@searchspring.com","email_verified":"true","exp":"1600003600"}`}
+	dao := newTestDAO(client, Policy{Domains: []string{"searchspring.com"}, Audiences: []string{"client-1"}})
+
+	email, err := dao.CheckUserLoggedIn("Bearer ya29.token")
+	require.NoError(t, err)
+	require.Equal(t, "jane@searchspring.com", email)
+	require.Equal(t, "access_token=ya29.token", client.form)
+
+	_, err = dao.CheckUserLoggedIn("Bearer ya29.token")
+	require.NoError(t, err)
+	require.Equal(t, 1, client.calls)
+
+	// the cached token stops working when it expires
+	now = now.Add(time.Hour)
+	defer func() { now = now.Add(-time.Hour) }()
+	_, err = dao.CheckUserLoggedIn("Bearer ya29.token")
+	require.EqualError(t, err, "authorization failed - token expired")
+}
+
+func TestCheckUserLoggedInInvalidToken(t *testing.T) {
+	client := &tokenInfoClient{status: 400, body: `{"error":"invalid_token"}`}
+	dao := newTestDAO(client, Policy{Domains: []string{"searchspring.com"}})
+
+	_, err := dao.CheckUserLoggedIn("revoked")
+	require.Error(t, err)
+	_, err = dao.CheckUserLoggedIn("")
+	require.Error(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{Domains: []string{"searchspring.com"}, Audiences: []string{"client-1"}}
+
+	require.NoError(t, policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@searchspring.com", EmailVerified: "true"}))
+	require.NoError(t, policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@searchspring.com", EmailVerified: "true", HostedDomain: "searchspring.com"}))
+
+	err := policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@example.com", EmailVerified: "true"})
+	require.ErrorIs(t, err, ErrForbidden)
+	err = policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@searchspring.com", EmailVerified: "true", HostedDomain: "example.com"})
+	require.ErrorIs(t, err, ErrForbidden)
+	err = policy.Check(&TokenInfo{Audience: "client-2", Email: "jane@searchspring.com", EmailVerified: "true"})
+	require.ErrorIs(t, err, ErrForbidden)
+	err = policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@searchspring.com", EmailVerified: "false"})
+	require.ErrorIs(t, err, ErrForbidden)
+	// the hosted domain doesn't stand in for the email domain
+	err = policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@example.com", EmailVerified: "true", HostedDomain: "searchspring.com"})
+	require.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestPolicyWithoutClientIDs(t *testing.T) {
+	policy := NewPolicy(common.EnvVars{})
+	require.Equal(t, []string{"searchspring.com"}, policy.Domains)
+	err := policy.Check(&TokenInfo{Audience: "client-1", Email: "jane@searchspring.com", EmailVerified: "true"})
+	require.ErrorIs(t, err, ErrForbidden)
+	require.Contains(t, err.Error(), "no Google client ids")
+}
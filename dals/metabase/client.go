@@ -0,0 +1,230 @@
+package metabase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grokify/go-metabase/metabase"
+)
+
+// RowLimit is the most rows Metabase returns for a native query
+const RowLimit = 2000
+
+// ErrTimeout is wrapped by errors for requests to Metabase that took too long
+var ErrTimeout = errors.New("metabase timed out")
+
+// AuthError is returned when Metabase refuses the username and password
+type AuthError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("metabase login failed - status code: %d: %s", e.StatusCode, e.Message)
+}
+
+// StatusError is returned for any other unexpected response status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("metabase query failed - status code: %d", e.StatusCode)
+}
+
+// QueryError is returned when Metabase ran the query but the database rejected it
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return "metabase query failed: " + e.Message
+}
+
+// RowLimitError is returned when a query returned RowLimit rows and couldn't be paged past them
+type RowLimitError struct {
+	Limit int
+}
+
+func (e *RowLimitError) Error() string {
+	return fmt.Sprintf("metabase query hit the %d row limit", e.Limit)
+}
+
+// retries is how many times a request is retried after a transient failure
+const retries = 3
+
+// login starts a session with the username and password
+func (s *DAOImpl) login(ctx context.Context) (string, error) {
+	body, _ := json.Marshal(map[string]string{"username": s.User, "password": s.Password})
+	data, status, err := s.post(ctx, "api/session", "", body)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusBadRequest || status == http.StatusUnauthorized {
+		return "", &AuthError{StatusCode: status, Message: errorMessage(data)}
+	}
+	if status < 200 || status > 299 {
+		return "", &StatusError{StatusCode: status, Body: string(data)}
+	}
+	response := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", err
+	}
+	if response.ID == "" {
+		return "", &AuthError{StatusCode: status, Message: "no session id"}
+	}
+	return response.ID, nil
+}
+
+// currentSession returns the session, logging in when there is none or it is still the
+// expired one. Concurrent queries that find the same expired session only log in once.
+func (s *DAOImpl) currentSession(ctx context.Context, expired string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session != "" && s.session != expired {
+		return s.session, nil
+	}
+	session, err := s.login(ctx)
+	if err != nil {
+		s.session = ""
+		return "", err
+	}
+	s.session = session
+	return session, nil
+}
+
+// dataset runs a native query against the database, logging in again once if the
+// session has expired. The request is abandoned when ctx is done.
+func (s *DAOImpl) dataset(ctx context.Context, q string) (metabase.DatasetQueryResults, error) {
+	body, _ := json.Marshal(metabase.DatasetQueryJsonQuery{
+		Database: databaseId,
+		Type:     "native",
+		Native:   metabase.DatasetQueryNative{Query: q},
+	})
+	session, err := s.currentSession(ctx, "")
+	if err != nil {
+		return metabase.DatasetQueryResults{}, err
+	}
+	data, status, err := s.post(ctx, "api/dataset", session, body)
+	if err == nil && status == http.StatusUnauthorized {
+		log.Println("metabase session expired, logging in again")
+		if session, err = s.currentSession(ctx, session); err != nil {
+			return metabase.DatasetQueryResults{}, err
+		}
+		data, status, err = s.post(ctx, "api/dataset", session, body)
+	}
+	if err != nil {
+		return metabase.DatasetQueryResults{}, err
+	}
+	if status < 200 || status > 299 {
+		return metabase.DatasetQueryResults{}, &StatusError{StatusCode: status, Body: string(data)}
+	}
+
+	// metabase answers 202 for queries the database rejected too
+	result := struct {
+		metabase.DatasetQueryResults
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return metabase.DatasetQueryResults{}, err
+	}
+	if result.Status == "failed" || result.Error != "" {
+		return metabase.DatasetQueryResults{}, &QueryError{Message: result.Error}
+	}
+	return result.DatasetQueryResults, nil
+}
+
+// post sends body to the API path, retrying with a doubling backoff while Metabase is
+// unreachable or answers that it is unavailable. It stops retrying once ctx is done.
+func (s *DAOImpl) post(ctx context.Context, path string, session string, body []byte) ([]byte, int, error) {
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		data, status, err := s.send(ctx, path, session, body)
+		if !transient(status, err) || attempt == retries || ctx.Err() != nil {
+			return data, status, err
+		}
+		log.Printf("metabase %s failed, retrying in %s", path, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (s *DAOImpl) send(ctx context.Context, path string, session string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.BaseURL, "/")+"/"+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.Header.Set("X-Metabase-Session", session)
+	}
+	res, err := s.Client.Do(req)
+	if err != nil {
+		if timeout(err) {
+			return nil, 0, fmt.Errorf("%w: %s", ErrTimeout, err.Error())
+		}
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil && timeout(err) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrTimeout, err.Error())
+	}
+	return data, res.StatusCode, err
+}
+
+// transient reports whether a request is worth retrying, timeouts aren't as Slack
+// won't wait for another attempt
+func transient(status int, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrTimeout)
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func timeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// errorMessage pulls the reason out of a metabase error response, which is either a
+// plain string or {"errors": {"field": "reason"}}
+func errorMessage(data []byte) string {
+	response := struct {
+		Errors  map[string]string `json:"errors"`
+		Message string            `json:"message"`
+	}{}
+	if json.Unmarshal(data, &response) != nil {
+		return strings.TrimSpace(string(data))
+	}
+	if response.Message != "" {
+		return response.Message
+	}
+	reasons := []string{}
+	for field, reason := range response.Errors {
+		reasons = append(reasons, field+" "+reason)
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ", ")
+}
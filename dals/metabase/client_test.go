@@ -0,0 +1,273 @@
+package metabase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/searchspring/nebo/search"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMetabase answers logins for nebo/secret and queries with the responses queued
+// in results, a session is only valid until expire is called
+type fakeMetabase struct {
+	*httptest.Server
+	logins  int
+	session string
+	results []fakeResult
+	queries []string
+}
+
+type fakeResult struct {
+	status int
+	body   string
+	delay  time.Duration
+}
+
+const accountsResult = `{"status":"completed","row_count":1,"data":{"rows":[[42,"shoes.com","Jane Doe",true,10,5,"Shopify","v3","abc123","Denver","CO"]],"cols":[{"name":"id"},{"name":"domainName"},{"name":"csm"},{"name":"active"},{"name":"familyMrr"},{"name":"mrr"},{"name":"platform_smart"},{"name":"integrationType"},{"name":"trackingCode"},{"name":"city"},{"name":"state"}]}}`
+
+func newFakeMetabase(t *testing.T, results ...fakeResult) *fakeMetabase {
+	f := &fakeMetabase{results: results}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/session":
+			credentials := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&credentials)
+			if credentials["username"] != "nebo" || credentials["password"] != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"errors":{"password":"did not match stored password"}}`))
+				return
+			}
+			f.logins++
+			f.session = fmt.Sprintf("session%d", f.logins)
+			w.Write([]byte(`{"id":"` + f.session + `"}`))
+		case "/api/dataset":
+			if r.Header.Get("X-Metabase-Session") != f.session {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthenticated"))
+				return
+			}
+			query := struct {
+				Native struct {
+					Query string `json:"query"`
+				} `json:"native"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&query)
+			f.queries = append(f.queries, query.Native.Query)
+			result := fakeResult{status: http.StatusAccepted, body: accountsResult}
+			if len(f.results) > 0 {
+				result, f.results = f.results[0], f.results[1:]
+			}
+			time.Sleep(result.delay)
+			w.WriteHeader(result.status)
+			w.Write([]byte(result.body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeMetabase) expire() {
+	f.session = "expired"
+}
+
+func newTestDAO(url string, password string) *DAOImpl {
+	dao := NewDAO(url, "nebo", password, "", nil).(*DAOImpl)
+	dao.Client = &http.Client{Timeout: 100 * time.Millisecond}
+	dao.Backoff = time.Millisecond
+	return dao
+}
+
+func TestQueryKeepsSession(t *testing.T) {
+	f := newFakeMetabase(t)
+	dao := newTestDAO(f.URL, "secret")
+
+	accounts, err := dao.QueryAccounts()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "shoes.com", accounts[0].Website)
+	require.Equal(t, "42", accounts[0].WebsiteId)
+
+	_, err = dao.Query("shoes")
+	require.NoError(t, err)
+	require.Equal(t, 1, f.logins)
+	require.Equal(t, 2, len(f.queries))
+}
+
+func TestQuerySearchesWebsiteHost(t *testing.T) {
+	f := newFakeMetabase(t)
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.Query("https://www.Shoes.com/store")
+	require.NoError(t, err)
+	require.Contains(t, f.queries[0], "name LIKE '%shoes.com%'")
+}
+
+func TestProfile(t *testing.T) {
+	f := newFakeMetabase(t, fakeResult{status: http.StatusAccepted, body: `{"status":"completed","row_count":1,"data":{"rows":[[42,"shoes.com",false,"abc123",true,0]],"cols":[{"name":"id"},{"name":"domainName"},{"name":"active"},{"name":"trackingCode"},{"name":"presales"},{"name":"sandbox"}]}}`})
+	dao := newTestDAO(f.URL, "secret")
+
+	accounts, err := dao.Profile("https://www.Shoes.com/")
+	require.NoError(t, err)
+	require.Equal(t, "Not active", accounts[0].Active)
+	require.True(t, accounts[0].Presales)
+	require.False(t, accounts[0].Sandbox)
+	require.Contains(t, f.queries[0], "presales, sandbox FROM websites WHERE (trackingCode = 'https://www.Shoes.com/' OR name LIKE '%shoes.com%')")
+	require.NotContains(t, f.queries[0], "!presales")
+}
+
+func TestQueryLogsInAgainWhenSessionExpires(t *testing.T) {
+	f := newFakeMetabase(t)
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.Query("shoes")
+	require.NoError(t, err)
+	f.expire()
+	_, err = dao.Query("boots")
+	require.NoError(t, err)
+	require.Equal(t, 2, f.logins)
+}
+
+func TestQueryAuthError(t *testing.T) {
+	f := newFakeMetabase(t)
+	dao := newTestDAO(f.URL, "wrong")
+
+	_, err := dao.Query("shoes")
+	var authErr *AuthError
+	require.ErrorAs(t, err, &authErr)
+	require.Equal(t, http.StatusUnauthorized, authErr.StatusCode)
+	require.Equal(t, "password did not match stored password", authErr.Message)
+	require.Empty(t, f.queries)
+}
+
+func TestQueryRetriesTransientFailures(t *testing.T) {
+	f := newFakeMetabase(t,
+		fakeResult{status: http.StatusServiceUnavailable},
+		fakeResult{status: http.StatusBadGateway},
+	)
+	dao := newTestDAO(f.URL, "secret")
+
+	accounts, err := dao.Query("shoes")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, 3, len(f.queries))
+}
+
+func TestSearchStopsRetryingWhenCancelled(t *testing.T) {
+	results := []fakeResult{}
+	for i := 0; i <= retries; i++ {
+		results = append(results, fakeResult{status: http.StatusServiceUnavailable, body: "down"})
+	}
+	f := newFakeMetabase(t, results...)
+	dao := newTestDAO(f.URL, "secret")
+	dao.Backoff = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := dao.Search(ctx, &search.Query{Text: "shoes"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	require.Equal(t, 1, len(f.queries))
+}
+
+func TestQueryGivesUpAfterRetries(t *testing.T) {
+	results := []fakeResult{}
+	for i := 0; i <= retries; i++ {
+		results = append(results, fakeResult{status: http.StatusServiceUnavailable, body: "down"})
+	}
+	f := newFakeMetabase(t, results...)
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.Query("shoes")
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	require.Equal(t, retries+1, len(f.queries))
+}
+
+func TestQueryStatusError(t *testing.T) {
+	f := newFakeMetabase(t, fakeResult{status: http.StatusInternalServerError, body: "boom"})
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.QueryNPS("shoes")
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, "metabase query failed - status code: 500", err.Error())
+	require.Equal(t, 1, len(f.queries))
+}
+
+func TestQueryFailed(t *testing.T) {
+	f := newFakeMetabase(t, fakeResult{status: http.StatusAccepted, body: `{"status":"failed","error":"Unknown column 'foo'"}`})
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.Query("shoes")
+	var queryErr *QueryError
+	require.ErrorAs(t, err, &queryErr)
+	require.Equal(t, "metabase query failed: Unknown column 'foo'", err.Error())
+}
+
+func TestQueryTimeout(t *testing.T) {
+	f := newFakeMetabase(t, fakeResult{status: http.StatusAccepted, body: accountsResult, delay: 200 * time.Millisecond})
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.Query("shoes")
+	require.ErrorIs(t, err, ErrTimeout)
+}
+
+// sitesResult is a page of domainFields rows for the ids from first to last
+func sitesResult(first int, last int) string {
+	rows := [][]interface{}{}
+	for id := first; id <= last; id++ {
+		rows = append(rows, []interface{}{fmt.Sprintf("site%d.com", id), fmt.Sprintf("site%d", id), id%2 == 1, id, "Shopify", "Jane Doe", 100.5, nil})
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"status":    "completed",
+		"row_count": len(rows),
+		"data": map[string]interface{}{
+			"rows": rows,
+			"cols": []map[string]string{{"name": "name"}, {"name": "trackingCode"}, {"name": "active"}, {"name": "id"},
+				{"name": "platform_smart"}, {"name": "csm"}, {"name": "mrr"}, {"name": "integrationType"}},
+		},
+	})
+	return string(data)
+}
+
+func TestQuerySitesPagesPastRowLimit(t *testing.T) {
+	f := newFakeMetabase(t,
+		fakeResult{status: http.StatusAccepted, body: sitesResult(1, RowLimit)},
+		fakeResult{status: http.StatusAccepted, body: sitesResult(RowLimit+1, RowLimit+5)},
+	)
+	dao := newTestDAO(f.URL, "secret")
+
+	sites, err := dao.QuerySites()
+	require.NoError(t, err)
+	require.Equal(t, RowLimit+5, len(sites))
+	require.Equal(t, DomainAndID{Website: "site2005.com", SiteId: "site2005", Active: true, Platform: "Shopify", CSM: "Jane Doe", MRR: 100.5}, sites[len(sites)-1])
+	require.False(t, sites[len(sites)-2].Active)
+	require.Equal(t, 2, len(f.queries))
+	require.Equal(t, "SELECT name, trackingCode, active, id, platform_smart, csm, mrr, integrationType FROM websites ORDER BY id LIMIT 2000", f.queries[0])
+	require.Equal(t, "SELECT name, trackingCode, active, id, platform_smart, csm, mrr, integrationType FROM websites WHERE id > 2000 ORDER BY id LIMIT 2000", f.queries[1])
+}
+
+func TestQuerySitesRowLimit(t *testing.T) {
+	f := newFakeMetabase(t, fakeResult{status: http.StatusAccepted, body: strings.Replace(sitesResult(1, RowLimit), `"id"`, `"website_id"`, 1)})
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.QuerySites()
+	var limitErr *RowLimitError
+	require.ErrorAs(t, err, &limitErr)
+	require.Equal(t, RowLimit, limitErr.Limit)
+}
+
+func TestNewDAOWithoutCredentials(t *testing.T) {
+	require.Nil(t, NewDAO("https://metabase.test/", "", "", "", nil))
+}
@@ -1,29 +1,51 @@
 package metabase
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
-	"regexp"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/grokify/go-metabase/metabase"
-	"github.com/grokify/go-metabase/metabaseutil"
-	metabaseOAuth "github.com/grokify/oauth2more/metabase"
+	"github.com/searchspring/nebo/cache"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/domains"
 	"github.com/searchspring/nebo/models"
+	qb "github.com/searchspring/nebo/querybuilder"
+	"github.com/searchspring/nebo/search"
 )
 
 type DAO interface {
-	QueryAll() ([]byte, error)
+	QuerySites() ([]DomainAndID, error)
 	QueryNPS(string) (*NpsInfo, error)
 	Query(string) ([]*models.AccountInfo, error)
+	Search(context.Context, *search.Query) ([]*models.AccountInfo, error)
+	QueryAccounts() ([]*models.AccountInfo, error)
+	Profile(string) ([]*models.AccountInfo, error)
 	StructFromResult(*metabase.DatasetQueryResultsData) (*NpsInfo, error)
 	ResultToMessage(string, *metabase.DatasetQueryResultsData) ([]*models.AccountInfo, error)
 	GetSearchKey() string
 }
 
+// DAOImpl logs in on the first query and keeps the session, logging in again when
+// Metabase reports it has expired
 type DAOImpl struct {
-	Client *metabase.APIClient
-	Key    string
+	Client   common.HTTPClient
+	BaseURL  string
+	User     string
+	Password string
+	Key      string
+	Cache    *cache.Cache
+	// Backoff is how long to wait before retrying a transient failure, it doubles each retry
+	Backoff time.Duration
+
+	mu      sync.Mutex
+	session string
 }
 
 type NpsInfo struct {
@@ -32,113 +54,252 @@ type NpsInfo struct {
 	FamilyMRR float64
 }
 
+// DomainAndID is a website in the site directory
 type DomainAndID struct {
-	Website string
-	SiteId  string
+	Website     string
+	SiteId      string
+	Active      bool
+	Platform    string
+	CSM         string
+	MRR         float64
+	Integration string
 }
 
 const databaseId = 5
 
-const domainFields = "name, trackingCode, active"
+const domainFields = "name, trackingCode, active, id, platform_smart, csm, mrr, integrationType"
 const npsFields = "active, mrr, familyMrr, csm, name"
-const accountFields = "domainName, csm, active, familyMrr, mrr, platform_smart, integrationType, trackingCode, city, state"
+const accountFields = "id, domainName, csm, active, familyMrr, mrr, platform_smart, integrationType, trackingCode, city, state"
 
-func NewDAO(metabaseURL string, metabaseUser string, metabasePassword string, metabaseToken string) DAO {
+// profileFields add the columns only the detailed view of one account shows
+const profileFields = accountFields + ", presales, sandbox"
 
-	config := metabaseOAuth.Config{
-		BaseURL:       metabaseURL,
-		Username:      metabaseUser,
-		Password:      metabasePassword,
-		SessionID:     metabaseToken,
-		TLSSkipVerify: true,
-	}
+// queryTTL is how long the results of a query are reused
+const queryTTL = 5 * time.Minute
 
-	apiClient, _, err := metabaseutil.NewApiClient(config)
-	if err != nil {
-		log.Println(err.Error())
+// NewDAO returns the metabase DAO or nil without credentials, metabaseToken is an
+// existing session to use until it expires. Query results are kept in c which may be nil.
+func NewDAO(metabaseURL string, metabaseUser string, metabasePassword string, metabaseToken string, c *cache.Cache) DAO {
+	if metabaseToken == "" && common.ContainsEmptyString(metabaseUser, metabasePassword) {
 		return nil
 	}
-
 	return &DAOImpl{
-		Client: apiClient,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+			// the metabase certificate isn't trusted
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+		BaseURL:  metabaseURL,
+		User:     metabaseUser,
+		Password: metabasePassword,
+		Cache:    c,
+		Backoff:  250 * time.Millisecond,
+		session:  metabaseToken,
 	}
 }
 
-func (s *DAOImpl) QueryAll() ([]byte, error) {
-	data := []DomainAndID{}
+// querySQL runs a native query against the websites database. Results of a query that
+// ran in the last few minutes come from the cache.
+func (s *DAOImpl) querySQL(ctx context.Context, q string) (metabase.DatasetQueryResults, error) {
+	key := cache.Key("metabase", q)
+	cached := metabase.DatasetQueryResults{}
+	if s.Cache.GetJSON(key, &cached) {
+		return cached, nil
+	}
+	info, err := s.dataset(ctx, q)
+	if err != nil {
+		return info, err
+	}
+	s.Cache.SetJSON(key, info, queryTTL)
+	return info, nil
+}
 
-	q := "SELECT " + domainFields + " " + "FROM websites WHERE active"
+// QuerySites returns every website, active or not, for the site directory
+func (s *DAOImpl) QuerySites() ([]DomainAndID, error) {
+	data := []DomainAndID{}
 
-	info, resp, err := metabaseutil.QuerySQL(s.Client, databaseId, q)
+	result, err := s.queryPages(domainFields)
 	if err != nil {
-		log.Fatal(err)
-		return []byte{}, err
-	} else if resp.StatusCode >= 300 {
-		log.Println(fmt.Sprintf("STATUS_CODE [%v]", resp.StatusCode))
-		return []byte{}, err
-	} else if info.RowCount == 2000 {
-		log.Println("DATABASE HAS SURPASSED QUERY LIMIT")
-		return []byte{}, err
+		return nil, err
 	}
 
-	rows := info.Data.Rows
-
-	for _, v := range rows {
-		data = append(data, DomainAndID{
-			Website: fmt.Sprintf("%s", v[0]),
-			SiteId:  fmt.Sprintf("%s", v[1]),
-		})
+	for _, row := range result.Rows {
+		site := DomainAndID{}
+		for k, col := range result.Cols {
+			value := row[k]
+			switch col.Name {
+			case "name":
+				site.Website = fmt.Sprintf("%s", value)
+			case "trackingCode":
+				site.SiteId = fmt.Sprintf("%s", value)
+			case "active":
+				site.Active = value == true || value == float64(1)
+			case "platform_smart":
+				if value != nil {
+					site.Platform = fmt.Sprint(value)
+				}
+			case "csm":
+				if value != nil {
+					site.CSM = fmt.Sprint(value)
+				}
+			case "mrr":
+				if mrr, ok := value.(float64); ok {
+					site.MRR = mrr
+				}
+			case "integrationType":
+				if value != nil {
+					site.Integration = fmt.Sprint(value)
+				}
+			}
+		}
+		data = append(data, site)
 	}
 
-	return json.Marshal(data)
+	return data, nil
 }
 
 func (s *DAOImpl) QueryNPS(search string) (*NpsInfo, error) {
-	reg, err := regexp.Compile("[^a-zA-Z0-9_.-]+")
+	search = strings.TrimSpace(search)
+	q := qb.Select(qb.MySQL, npsFields).From("websites").
+		Where(qb.Raw("active")).
+		Where(qb.Contains("name", search)).
+		OrderBy("mrr DESC").String()
+
+	info, err := s.querySQL(context.Background(), q)
 	if err != nil {
-		return nil, err
+		return &NpsInfo{}, err
 	}
 
-	sanitized := reg.ReplaceAllString(search, "")
+	return s.StructFromResult(&info.Data)
+}
+
+// Query matches the search text against website name, platform and tracking code
+func (s *DAOImpl) Query(text string) ([]*models.AccountInfo, error) {
+	return s.Search(context.Background(), &search.Query{Text: text})
+}
 
-	q := "SELECT " + npsFields + " " +
-		"FROM websites WHERE active " +
-		"AND name LIKE '%" + sanitized + "%' ORDER BY mrr DESC"
+// columns maps search fields onto the websites table
+var columns = map[search.Field]string{
+	search.Platform:    "platform_smart",
+	search.CSM:         "csm",
+	search.State:       "state",
+	search.City:        "city",
+	search.MRR:         "mrr",
+	search.FamilyMRR:   "familyMrr",
+	search.Active:      "active",
+	search.Integration: "integrationType",
+	search.Site:        "trackingCode",
+	search.Website:     "name",
+}
 
-	info, resp, err := metabaseutil.QuerySQL(s.Client, databaseId, q)
+// Search returns the websites matching a parsed search, only active sites are
+// returned unless the search has an active condition. The query is abandoned when ctx
+// is done.
+func (s *DAOImpl) Search(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error) {
+	text := domains.SearchTerm(query.Text)
+	builder := qb.Select(qb.MySQL, accountFields).From("websites").
+		Where(qb.Raw("!presales AND !sandbox"))
+	if _, ok := query.Condition(search.Active); !ok {
+		builder.Where(qb.Raw("active"))
+	}
+	if text != "" {
+		builder.Where(qb.Or(
+			qb.Contains("name", text),
+			qb.Contains("platform_smart", text),
+			qb.Equals("trackingCode", text),
+		))
+	}
+	// conditions without a column are checked once the results are back
+	remaining := &search.Query{}
+	for _, c := range query.Conditions {
+		column, ok := columns[c.Field]
+		if !ok {
+			remaining.Conditions = append(remaining.Conditions, c)
+			continue
+		}
+		builder.Where(c.Where(column))
+	}
+	q := builder.OrderBy("mrr DESC").String()
+	info, err := s.querySQL(ctx, q)
 	if err != nil {
-		log.Fatal(err)
-		return &NpsInfo{}, err
-	} else if resp.StatusCode >= 300 {
-		log.Println(fmt.Sprintf("STATUS_CODE [%v]", resp.StatusCode))
-		return &NpsInfo{}, err
+		return []*models.AccountInfo{}, err
 	}
 
-	return s.StructFromResult(&info.Data)
+	accounts, err := s.ResultToMessage(text, &info.Data)
+	if err != nil {
+		return nil, err
+	}
+	return remaining.Filter(accounts), nil
 }
 
-func (s *DAOImpl) Query(search string) ([]*models.AccountInfo, error) {
-	reg, err := regexp.Compile("[^a-zA-Z0-9_.-]+")
+// QueryAccounts returns every active customer website, highest MRR first
+func (s *DAOImpl) QueryAccounts() ([]*models.AccountInfo, error) {
+	result, err := s.queryPages(accountFields, qb.Raw("active AND !presales AND !sandbox"))
 	if err != nil {
-		return nil, err
+		return []*models.AccountInfo{}, err
 	}
 
-	sanitized := reg.ReplaceAllString(search, "")
+	accounts := accountsFromResult(result)
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].MRR > accounts[j].MRR
+	})
+	return accounts, nil
+}
 
-	q := "SELECT " + accountFields + " " +
-		"FROM websites WHERE active AND !presales AND !sandbox " +
-		"AND (name LIKE '%" + sanitized + "%' OR platform_smart LIKE '%" + sanitized +
-		"%' OR trackingCode = '" + sanitized + "') ORDER BY mrr DESC"
-	info, resp, err := metabaseutil.QuerySQL(s.Client, databaseId, q)
+// Profile returns the websites with the tracking code or website key, with the columns
+// for the detailed view. Inactive, presales and sandbox sites are included and the name
+// only has to contain key, callers pick the exact match.
+func (s *DAOImpl) Profile(key string) ([]*models.AccountInfo, error) {
+	key = strings.TrimSpace(key)
+	q := qb.Select(qb.MySQL, profileFields).From("websites").
+		Where(qb.Or(
+			qb.Equals("trackingCode", key),
+			qb.Contains("name", domains.SearchTerm(key)),
+		)).
+		OrderBy("mrr DESC").String()
+	info, err := s.querySQL(context.Background(), q)
 	if err != nil {
-		log.Fatal(err)
-		return []*models.AccountInfo{}, err
-	} else if resp.StatusCode >= 300 {
-		log.Println(fmt.Sprintf("STATUS_CODE [%v]", resp.StatusCode))
-		return []*models.AccountInfo{}, err
+		return nil, err
 	}
+	return accountsFromResult(&info.Data), nil
+}
+
+// queryPages selects every matching website, fields must include id. Metabase returns
+// at most RowLimit rows for a query so the websites are fetched RowLimit at a time in
+// id order, each page starting after the last id of the one before.
+func (s *DAOImpl) queryPages(fields string, conditions ...qb.Condition) (*metabase.DatasetQueryResultsData, error) {
+	all := &metabase.DatasetQueryResultsData{}
+	after := float64(-1)
+	for {
+		builder := qb.Select(qb.MySQL, fields).From("websites")
+		for _, c := range conditions {
+			builder.Where(c)
+		}
+		if after >= 0 {
+			builder.Where(qb.Compare("id", ">", after))
+		}
+		info, err := s.querySQL(context.Background(), builder.OrderBy("id").Limit(RowLimit).String())
+		if err != nil {
+			return nil, err
+		}
+		all.Cols = info.Data.Cols
+		all.Rows = append(all.Rows, info.Data.Rows...)
+		if len(info.Data.Rows) < RowLimit {
+			return all, nil
+		}
 
-	return s.ResultToMessage(sanitized, &info.Data)
+		// a full page means there may be more, carry on from the last id
+		last := float64(-1)
+		for k, col := range info.Data.Cols {
+			if id, ok := info.Data.Rows[len(info.Data.Rows)-1][k].(float64); ok && col.Name == "id" {
+				last = id
+			}
+		}
+		if last <= after {
+			return nil, &RowLimitError{Limit: RowLimit}
+		}
+		after = last
+	}
 }
 
 // formatting results
@@ -180,6 +341,10 @@ func (s *DAOImpl) StructFromResult(result *metabase.DatasetQueryResultsData) (*N
 }
 
 func (s *DAOImpl) ResultToMessage(search string, result *metabase.DatasetQueryResultsData) ([]*models.AccountInfo, error) {
+	return accountsFromResult(result), nil
+}
+
+func accountsFromResult(result *metabase.DatasetQueryResultsData) []*models.AccountInfo {
 	accounts := []*models.AccountInfo{}
 	if len(result.Rows) > 0 {
 		for i := range result.Rows {
@@ -194,9 +359,16 @@ func (s *DAOImpl) ResultToMessage(search string, result *metabase.DatasetQueryRe
 			siteId := ""
 			city := "unknown"
 			state := ""
+			websiteId := ""
+			presales := false
+			sandbox := false
 			for k, colInfo := range result.Cols {
 				value := result.Rows[i][k]
 				switch colInfo.Name {
+				case "id":
+					if id, ok := value.(float64); ok {
+						websiteId = strconv.FormatFloat(id, 'f', -1, 64)
+					}
 				case "domainName":
 					if value != nil {
 						website = fmt.Sprint(value)
@@ -225,6 +397,10 @@ func (s *DAOImpl) ResultToMessage(search string, result *metabase.DatasetQueryRe
 					if value != nil {
 						siteId = fmt.Sprint(value)
 					}
+				case "active":
+					if value == false || value == float64(0) {
+						active = "Not active"
+					}
 				case "city":
 					if value != nil {
 						city = fmt.Sprint(value)
@@ -233,6 +409,10 @@ func (s *DAOImpl) ResultToMessage(search string, result *metabase.DatasetQueryRe
 					if value != nil {
 						state = fmt.Sprint(value)
 					}
+				case "presales":
+					presales = value == true || value == float64(1)
+				case "sandbox":
+					sandbox = value == true || value == float64(1)
 				}
 			}
 			accounts = append(accounts, &models.AccountInfo{
@@ -247,14 +427,14 @@ func (s *DAOImpl) ResultToMessage(search string, result *metabase.DatasetQueryRe
 				SiteId:      siteId,
 				City:        city,
 				State:       state,
+				WebsiteId:   websiteId,
+				Presales:    presales,
+				Sandbox:     sandbox,
 			})
-			if i > 20 {
-				break
-			}
 		}
 	}
 
-	return accounts, nil
+	return accounts
 }
 
 // helper functions
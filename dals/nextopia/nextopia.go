@@ -1,37 +1,96 @@
 package nextopia
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/cache"
 	common "github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/domains"
+	"github.com/searchspring/nebo/models"
 )
 
 // DAO acts as the nextopia DAO
 type DAO interface {
-	Query(query string) ([]byte, error)
+	Search(ctx context.Context, query string) ([]*NextopiaAccount, error)
+	Accounts(ctx context.Context, query string) ([]*models.AccountInfo, error)
 }
 
-// DAOImpl defines the properties of the DAO
+// DAOImpl keeps the client report in memory, fetching it again once it is older than
+// customersTTL
 type DAOImpl struct {
-	Client    *http.Client
-	User      string
-	Password  string
-	Customers map[string][]string
+	Client   common.HTTPClient
+	BaseURL  string
+	User     string
+	Password string
+	Cache    *cache.Cache
+
+	mu       sync.Mutex
+	accounts []*NextopiaAccount
+	loaded   time.Time
+	refresh  *refresh
+	// failed is when the last fetch failed with failure, zero once one succeeds
+	failed  time.Time
+	failure error
+	now     func() time.Time
+}
+
+// refresh is a fetch of the client report shared by every search waiting for it, done
+// is closed once it is over
+type refresh struct {
+	done chan struct{}
+	err  error
+}
+
+// NextopiaAccount is a row of the client report
+type NextopiaAccount struct {
+	ID1     string
+	ID2     string
+	Name    string
+	Status  string
+	URL     string
+	Plan    string
+	Version string
+	System  string
+	// LastActivity is zero when the account has never been active
+	LastActivity time.Time
+}
+
+// Active reports whether the account's status is ACTIVE
+func (a *NextopiaAccount) Active() bool {
+	return strings.EqualFold(a.Status, "ACTIVE")
 }
 
-// NewDAO returns the nextopia DAO
-func NewDAO(nxUser string, nxPassword string) DAO {
+// customersTTL is how long the client report is reused, it lists every account so
+// it is large and slow to fetch
+const customersTTL = time.Hour
+
+// refreshBackoff is how long after a failed fetch the client report isn't fetched again,
+// searches meanwhile use the previous report or fail straight away
+const refreshBackoff = time.Minute
+
+const clientReportURL = "http://client-report.nxtpd.com/"
+
+// NewDAO returns the nextopia DAO, the client report is kept in c which may be nil
+func NewDAO(nxUser string, nxPassword string, c *cache.Cache) DAO {
 	if common.ContainsEmptyString(nxUser, nxPassword) {
 		return nil
 	}
 	return &DAOImpl{
 		User:     nxUser,
 		Password: nxPassword,
-		Client:   http.DefaultClient,
+		BaseURL:  clientReportURL,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Cache:    c,
+		now:      time.Now,
 	}
 }
 
@@ -40,80 +99,291 @@ type resultData struct {
 	Data [][]string `json:"data"`
 }
 
-// Query queries the nextopia client report DB using provided query string
-func (d *DAOImpl) Query(query string) ([]byte, error) {
-	if d.Customers == nil {
-		res, err := d.Client.Get("http://" + d.User + ":" + d.Password + "@client-report.nxtpd.com/api/data-table.php?table=accounts&_=1592606239141")
-		if err != nil {
-			return nil, err
-		}
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
+// cachedReport is the client report as it is kept in the cache, Fetched is when it was
+// read from the API so a report from the cache isn't reused for longer than customersTTL
+type cachedReport struct {
+	Report  *resultData `json:"report"`
+	Fetched time.Time   `json:"fetched"`
+}
+
+// Search returns the accounts matching the query, best matches first. Fetching the
+// client report is abandoned when ctx is done.
+func (d *DAOImpl) Search(ctx context.Context, query string) ([]*NextopiaAccount, error) {
+	accounts, err := d.loadAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rank(accounts, query), nil
+}
+
+// Accounts returns the customers matching the query as account infos
+func (d *DAOImpl) Accounts(ctx context.Context, query string) ([]*models.AccountInfo, error) {
+	found, err := d.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	accounts := []*models.AccountInfo{}
+	for _, account := range found {
+		accounts = append(accounts, toAccountInfo(account))
+	}
+	return accounts, nil
+}
+
+// loadAccounts returns the client report. Once it is older than customersTTL the old
+// report is returned while a new one is fetched in the background, only the first
+// search has to wait. One fetch runs at a time and none for refreshBackoff after one
+// fails, a search whose ctx is done before the first fetch finishes gets ctx's error.
+func (d *DAOImpl) loadAccounts(ctx context.Context) ([]*NextopiaAccount, error) {
+	d.mu.Lock()
+	if d.accounts != nil && d.now().Sub(d.loaded) < customersTTL {
+		defer d.mu.Unlock()
+		return d.accounts, nil
+	}
+	r := d.refresh
+	if r == nil {
+		if d.now().Sub(d.failed) < refreshBackoff {
+			defer d.mu.Unlock()
+			if d.accounts != nil {
+				return d.accounts, nil
+			}
+			return nil, d.failure
 		}
-		resultData := &resultData{}
+		r = &refresh{done: make(chan struct{})}
+		d.refresh = r
+		go d.refreshAccounts(r)
+	}
+	if d.accounts != nil {
+		defer d.mu.Unlock()
+		return d.accounts, nil
+	}
+	d.mu.Unlock()
 
-		err = json.Unmarshal(body, resultData)
-		if err != nil {
-			return nil, err
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.accounts != nil {
+		return d.accounts, nil
+	}
+	return nil, r.err
+}
+
+// refreshAccounts fetches the client report for r, the fetch isn't tied to any one
+// search so it uses its own context and the client's timeout
+func (d *DAOImpl) refreshAccounts(r *refresh) {
+	report, err := d.fetchReport(context.Background())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer close(r.done)
+	d.refresh = nil
+	if err != nil {
+		r.err = err
+		d.failed = d.now()
+		d.failure = err
+		if d.accounts != nil {
+			log.Printf("nextopia client report refresh failed, using the previous report: %s", err.Error())
 		}
-		d.Customers = map[string][]string{}
-		for _, row := range resultData.Data {
-			d.Customers[row[0]] = row
+		return
+	}
+	accounts := []*NextopiaAccount{}
+	for _, row := range report.Report.Data {
+		if account, ok := parseAccount(row); ok {
+			accounts = append(accounts, account)
+		}
+	}
+	d.accounts = accounts
+	d.loaded = report.Fetched
+	d.failed = time.Time{}
+	d.failure = nil
+}
+
+// fetchReport reads the client report from the cache, when another instance fetched it
+// less than customersTTL ago, or from the client report API
+func (d *DAOImpl) fetchReport(ctx context.Context) (*cachedReport, error) {
+	key := cache.Key("nextopia", "report", d.User)
+	cached := &cachedReport{}
+	if d.Cache.GetJSON(key, cached) && cached.Report != nil && d.now().Sub(cached.Fetched) < customersTTL {
+		return cached, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(d.BaseURL, "/")+"/api/data-table.php?table=accounts", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(d.User, d.Password)
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("nextopia client report failed - status code: %d", res.StatusCode)
+	}
+	resultData := &resultData{}
+	if err := json.Unmarshal(body, resultData); err != nil {
+		return nil, err
+	}
+	report := &cachedReport{Report: resultData, Fetched: d.now()}
+	d.Cache.SetJSON(key, report, customersTTL)
+	return report, nil
+}
+
+// column positions in the client report
+const (
+	ID1           = 0
+	ID2           = 1
+	NAME          = 2
+	STATUS        = 3
+	URL           = 4
+	TYPE          = 5
+	VERSION       = 7
+	SYSTEM        = 8
+	LAST_ACTIVITY = 9
+)
+
+const lastActivityLayout = "2006-01-02 15:04:05"
+
+// parseAccount reads a client report row, rows without an id are skipped
+func parseAccount(row []string) (*NextopiaAccount, bool) {
+	column := func(i int) string {
+		if i < len(row) {
+			return strings.TrimSpace(row[i])
 		}
+		return ""
 	}
-	msg := d.findMatch(query)
-	return json.Marshal(msg)
-}
-
-const ID1 = 0
-const ID2 = 1
-const NAME = 2
-const URL = 4
-const TYPE = 5
-const VERSION = 7
-const SYSTEM = 8
-
-func (d *DAOImpl) findMatch(query string) *slack.Msg {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeInChannel,
-		Text:         "matches",
-		Attachments:  []slack.Attachment{},
-	}
-	for _, value := range d.Customers {
-		if matches(value, query) {
-			color := "3A23AD" // Searchspring purple
-			text := "URL: " + value[URL] +
-				"\nID 1: " + value[ID1] +
-				"\nID 2: " + value[ID2] +
-				"\nType: " + value[TYPE] +
-				"\nVersion: " + value[VERSION] + ", System: " + value[SYSTEM]
-
-			msg.Attachments = append(msg.Attachments, slack.Attachment{
-				Color:      "#" + color,
-				Text:       text,
-				AuthorName: value[NAME],
-			})
+	if column(ID1) == "" {
+		return nil, false
+	}
+	account := &NextopiaAccount{
+		ID1:     column(ID1),
+		ID2:     column(ID2),
+		Name:    column(NAME),
+		Status:  column(STATUS),
+		URL:     column(URL),
+		Plan:    column(TYPE),
+		Version: column(VERSION),
+		System:  column(SYSTEM),
+	}
+	// never active accounts have 0000-00-00 00:00:00 which doesn't parse
+	if at, err := time.Parse(lastActivityLayout, column(LAST_ACTIVITY)); err == nil {
+		account.LastActivity = at
+	}
+	return account, true
+}
+
+// match ranks, lower is better
+const (
+	matchID = iota
+	matchIDPrefix
+	matchExact
+	matchPrefix
+	matchContains
+	matchFuzzy
+	noMatch
+)
+
+// minFuzzyLength is the shortest search that is matched against URLs with typos
+const minFuzzyLength = 4
+
+// matchRank is how well the account matches query, ids match by prefix and the name
+// and URL anywhere, ignoring case. URLs are compared by their canonical host, so
+// www.123DJ.com/ matches 123dj.com, and the name ec_123djcom matches 123dj.com too.
+// Failing that the URL can be a typo or two away from the query.
+func matchRank(account *NextopiaAccount, query string) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	best := noMatch
+	for _, id := range []string{account.ID1, account.ID2} {
+		id = strings.ToLower(id)
+		if id == query {
+			return matchID
 		}
-		if len(msg.Attachments) > 100 {
-			break
+		if strings.HasPrefix(id, query) && best > matchIDPrefix {
+			best = matchIDPrefix
 		}
 	}
-	if len(msg.Attachments) == 0 {
-		msg.Text = "No Matches :("
+	term := domains.SearchTerm(query)
+	name := strings.ToLower(account.Name)
+	host := domains.Canonical(account.URL)
+	rank := noMatch
+	switch {
+	case name != "" && name == query, host != "" && host == term:
+		rank = matchExact
+	case name != "" && strings.HasPrefix(name, query), host != "" && strings.HasPrefix(host, term):
+		rank = matchPrefix
+	case strings.Contains(name, query), host != "" && strings.Contains(host, term),
+		domains.Compact(term) != "" && strings.Contains(domains.Compact(name), domains.Compact(term)):
+		rank = matchContains
+	case len(query) >= minFuzzyLength && domains.Score(query, account.URL) >= domains.MinScore:
+		rank = matchFuzzy
+	}
+	if rank < best {
+		best = rank
+	}
+	return best
+}
+
+// rank returns the accounts matching query, best match first then active accounts,
+// the most recently active and name order
+func rank(accounts []*NextopiaAccount, query string) []*NextopiaAccount {
+	type ranked struct {
+		account *NextopiaAccount
+		rank    int
+	}
+	found := []ranked{}
+	for _, account := range accounts {
+		if r := matchRank(account, query); r != noMatch {
+			found = append(found, ranked{account, r})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.account.Active() != b.account.Active() {
+			return a.account.Active()
+		}
+		if !a.account.LastActivity.Equal(b.account.LastActivity) {
+			return a.account.LastActivity.After(b.account.LastActivity)
+		}
+		if a.account.Name != b.account.Name {
+			return a.account.Name < b.account.Name
+		}
+		return a.account.ID1 < b.account.ID1
+	})
+	result := []*NextopiaAccount{}
+	for _, r := range found {
+		result = append(result, r.account)
 	}
-	return msg
+	return result
 }
 
-func matches(customer []string, query string) bool {
-	if strings.HasPrefix(customer[ID1], query) {
-		return true
+func toAccountInfo(account *NextopiaAccount) *models.AccountInfo {
+	active := "Active"
+	if !account.Active() {
+		active = "Not active"
 	}
-	if strings.HasPrefix(customer[ID2], query) {
-		return true
+	website := "unknown"
+	if account.URL != "" {
+		website = account.URL
 	}
-	if strings.Contains(customer[NAME], query) {
-		return true
+	return &models.AccountInfo{
+		Website:     website,
+		Manager:     "unknown",
+		Active:      active,
+		MRR:         -1,
+		FamilyMRR:   -1,
+		Platform:    "unknown",
+		Integration: account.Version + ", " + account.System,
+		Provider:    "Nextopia",
+		SiteId:      account.ID1,
+		City:        "unknown",
+		State:       "unknown",
 	}
-	return false
 }
@@ -0,0 +1,258 @@
+package nextopia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/searchspring/nebo/cache"
+	"github.com/stretchr/testify/require"
+)
+
+const clientReport = `{"result":"success","data":[
+	["50ae9d89c8d2879b028227bad4ad0220","54762cbb0dc2475aa35485a26c79cf41","","INACTIVE","","Trial","n\/a","32-bit","legacy","0000-00-00 00:00:00"],
+	["00b5a6084631611ae5ff7e6d037c7a1e","b913c134faf624e8e26b2f841a346352","ec_101inkscom","INACTIVE","101inks.com","Trial","n\/a","unset","legacy","2015-10-07 14:23:28"],
+	["ee33869e9bdf9371963dca152444c212","6130a8c8e4e4543953af4118186b145f","ec_123djcom","ACTIVE","123dj.com","Professional","n\/a","unset","v1.5.1","2020-06-17 18:25:59"],
+	["3502dc102d967598693d671cd0a82d68","7213b73fa377d8572ae0731e6aa0d3f1","ec_123healthshopcouk","INACTIVE","123healthshop.co.uk","Trial","n\/a","unset","v2.0","2018-06-16 10:37:52"],
+	["c3f3888a9c554f58ccd420a6491284a4","cec4a2a2d6680cf83c3cf8685176e6c5","ec_123securityproductscom","ACTIVE","123securityproducts.com","Professional","n\/a","watson","v2.0","2020-06-11 14:19:40"],
+	["","","ec_noid","ACTIVE","","","","","",""]
+]}`
+
+// fakeClientReport answers with the client report while up is true, once release is
+// closed when it is set
+type fakeClientReport struct {
+	*httptest.Server
+	requests int
+	up       bool
+	release  chan struct{}
+}
+
+func newTestDAO(t *testing.T, now *time.Time) (*DAOImpl, *fakeClientReport) {
+	f := &fakeClientReport{up: true}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "nx", user)
+		require.Equal(t, "secret", password)
+		require.Equal(t, "accounts", r.URL.Query().Get("table"))
+		f.requests++
+		if f.release != nil {
+			<-f.release
+		}
+		if !f.up {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(clientReport))
+	}))
+	t.Cleanup(f.Close)
+	dao := NewDAO("nx", "secret", nil).(*DAOImpl)
+	dao.BaseURL = f.URL
+	dao.now = func() time.Time { return *now }
+	return dao, f
+}
+
+func TestSearchParsesAccounts(t *testing.T) {
+	now := time.Now()
+	dao, _ := newTestDAO(t, &now)
+
+	accounts, err := dao.Search(context.Background(), "ee33869e9bdf9371963dca152444c212")
+	require.NoError(t, err)
+	require.Equal(t, []*NextopiaAccount{{
+		ID1:          "ee33869e9bdf9371963dca152444c212",
+		ID2:          "6130a8c8e4e4543953af4118186b145f",
+		Name:         "ec_123djcom",
+		Status:       "ACTIVE",
+		URL:          "123dj.com",
+		Plan:         "Professional",
+		Version:      "unset",
+		System:       "v1.5.1",
+		LastActivity: time.Date(2020, 6, 17, 18, 25, 59, 0, time.UTC),
+	}}, accounts)
+
+	accounts, err = dao.Search(context.Background(), "50ae9d89")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts))
+	require.True(t, accounts[0].LastActivity.IsZero())
+	require.False(t, accounts[0].Active())
+}
+
+func TestSearchRanksMatches(t *testing.T) {
+	now := time.Now()
+	dao, _ := newTestDAO(t, &now)
+
+	names := func(accounts []*NextopiaAccount) []string {
+		result := []string{}
+		for _, a := range accounts {
+			result = append(result, a.Name)
+		}
+		return result
+	}
+
+	// active first, then the most recently active, the row without an id is left out
+	accounts, err := dao.Search(context.Background(), "EC_1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ec_123djcom", "ec_123securityproductscom", "ec_123healthshopcouk", "ec_101inkscom"}, names(accounts))
+
+	// the URL is matched too, an exact match beats a prefix
+	accounts, err = dao.Search(context.Background(), "123DJ.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ec_123djcom"}, names(accounts))
+
+	accounts, err = dao.Search(context.Background(), "co.uk")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ec_123healthshopcouk"}, names(accounts))
+
+	// an id prefix beats a name match
+	accounts, err = dao.Search(context.Background(), "c3")
+	require.NoError(t, err)
+	require.Equal(t, "ec_123securityproductscom", accounts[0].Name)
+
+	// the name is compared without punctuation and the URL by its host
+	accounts, err = dao.Search(context.Background(), "https://www.101inks.com/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ec_101inkscom"}, names(accounts))
+
+	// a typo in the URL is still found, after anything that matches
+	accounts, err = dao.Search(context.Background(), "123dk.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ec_123djcom"}, names(accounts))
+
+	accounts, err = dao.Search(context.Background(), "nothing like it")
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+}
+
+// waitForRefresh blocks until the fetch running in the background, if any, is over
+func waitForRefresh(dao *DAOImpl) {
+	dao.mu.Lock()
+	r := dao.refresh
+	dao.mu.Unlock()
+	if r != nil {
+		<-r.done
+	}
+}
+
+func TestSearchRefreshesReport(t *testing.T) {
+	now := time.Now()
+	dao, f := newTestDAO(t, &now)
+
+	_, err := dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	_, err = dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	require.Equal(t, 1, f.requests)
+
+	// the old report is used while the new one is fetched
+	now = now.Add(customersTTL)
+	f.release = make(chan struct{})
+	accounts, err := dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(accounts))
+	close(f.release)
+	waitForRefresh(dao)
+	require.Equal(t, 2, f.requests)
+	f.release = nil
+
+	// a failed refresh keeps the previous report and isn't retried straight away
+	now = now.Add(customersTTL)
+	f.up = false
+	accounts, err = dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(accounts))
+	waitForRefresh(dao)
+	require.Equal(t, 3, f.requests)
+
+	accounts, err = dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(accounts))
+	waitForRefresh(dao)
+	require.Equal(t, 3, f.requests)
+
+	now = now.Add(refreshBackoff)
+	f.up = true
+	_, err = dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	waitForRefresh(dao)
+	require.Equal(t, 4, f.requests)
+	_, err = dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	require.Equal(t, 4, f.requests)
+}
+
+func TestSearchSharesOneFetch(t *testing.T) {
+	now := time.Now()
+	dao, f := newTestDAO(t, &now)
+	f.release = make(chan struct{})
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := dao.Search(context.Background(), "ec_")
+			results <- err
+		}()
+	}
+
+	// the fetch doesn't hold the lock, a search that gives up returns straight away
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := dao.Search(ctx, "ec_")
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	close(f.release)
+	require.NoError(t, <-results)
+	require.NoError(t, <-results)
+	require.Equal(t, 1, f.requests)
+}
+
+func TestSearchUsesFetchTimeOfCachedReport(t *testing.T) {
+	now := time.Now()
+	dao, f := newTestDAO(t, &now)
+	dao.Cache = cache.New(cache.NewMemory(10), nil)
+	report := &resultData{}
+	require.NoError(t, json.Unmarshal([]byte(clientReport), report))
+	dao.Cache.SetJSON(cache.Key("nextopia", "report", "nx"), &cachedReport{Report: report, Fetched: now.Add(-50 * time.Minute)}, customersTTL)
+
+	accounts, err := dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(accounts))
+	require.Equal(t, 0, f.requests)
+
+	// another instance fetched it 50 minutes ago so it is refreshed within the hour
+	now = now.Add(15 * time.Minute)
+	_, err = dao.Search(context.Background(), "ec_")
+	require.NoError(t, err)
+	waitForRefresh(dao)
+	require.Equal(t, 1, f.requests)
+}
+
+func TestSearchReportUnavailable(t *testing.T) {
+	now := time.Now()
+	dao, f := newTestDAO(t, &now)
+	f.up = false
+
+	_, err := dao.Search(context.Background(), "ec_")
+	require.EqualError(t, err, "nextopia client report failed - status code: 502")
+
+	// searches right after fail without waiting on another fetch
+	_, err = dao.Search(context.Background(), "ec_")
+	require.EqualError(t, err, "nextopia client report failed - status code: 502")
+	require.Equal(t, 1, f.requests)
+}
+
+func TestAccounts(t *testing.T) {
+	now := time.Now()
+	dao, _ := newTestDAO(t, &now)
+
+	accounts, err := dao.Accounts(context.Background(), "101inks")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "101inks.com", accounts[0].Website)
+	require.Equal(t, "Not active", accounts[0].Active)
+	require.Equal(t, "00b5a6084631611ae5ff7e6d037c7a1e", accounts[0].SiteId)
+	require.Equal(t, "Nextopia", accounts[0].Provider)
+	require.Equal(t, "unset, legacy", accounts[0].Integration)
+}
@@ -1,82 +1,248 @@
 package salesforce
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"regexp"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/searchspring/nebo/cache"
 	common "github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/domains"
 	"github.com/searchspring/nebo/models"
+	qb "github.com/searchspring/nebo/querybuilder"
+	"github.com/searchspring/nebo/search"
 	"github.com/simpleforce/simpleforce"
 )
 
 // DAO acts as the salesforce DAO
 type DAO interface {
 	Query(query string) ([]*models.AccountInfo, error)
+	Search(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error)
+	QueryCustomers() ([]*models.AccountInfo, error)
+	Profile(key string) ([]*models.AccountInfo, error)
 	ResultToMessage(query string, result *simpleforce.QueryResult) ([]*models.AccountInfo, error)
 	GetSearchKey() string
 }
 
-// DAOImpl defines the properties of the DAO
+// DAOImpl defines the properties of the DAO. It logs in on the first query and keeps
+// the session, logging in again when Salesforce reports the session has expired.
 type DAOImpl struct {
-	Client *simpleforce.Client
+	Client      common.HTTPClient
+	Credentials Credentials
+	Cache       *cache.Cache
+
+	mu      sync.Mutex
+	session *session
+	now     func() time.Time
 }
 
-const selectFields = "Type, Website, CS_Manager__r.Name, Family_MRR__c, Chargify_MRR__c, Platform__c, Integration_Type__c, Chargify_Source__c, Tracking_Code__c, BillingCity, BillingCountry, BillingState"
+// queryTTL is how long the accounts returned by a query are reused
+const queryTTL = 5 * time.Minute
+
+const selectFields = "Id, Type, Website, CS_Manager__r.Name, Family_MRR__c, Chargify_MRR__c, Platform__c, Integration_Type__c, Chargify_Source__c, Tracking_Code__c, BillingCity, BillingCountry, BillingState"
+
+// profileFields add the Account fields only the detailed view of one account shows
+const profileFields = selectFields + ", Owner.Name, CreatedDate, Parent.Name"
+
+// createdLayout is how Salesforce formats datetime fields
+const createdLayout = "2006-01-02T15:04:05.000-0700"
 
-// NewDAO returns the salesforce DAO
-func NewDAO(sfURL string, sfUser string, sfPassword string, sfToken string) DAO {
-	if common.ContainsEmptyString(sfURL, sfUser, sfPassword, sfToken) {
+// NewDAO returns the salesforce DAO or nil when neither login flow is configured, query
+// results are kept in c which may be nil
+func NewDAO(creds Credentials, c *cache.Cache) DAO {
+	if !creds.Configured() {
 		return nil
 	}
-	client := simpleforce.NewClient(sfURL, simpleforce.DefaultClientID, simpleforce.DefaultAPIVersion)
-	if client == nil {
-		log.Println("nil returned from client creation")
-		return nil
+	return &DAOImpl{
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		Credentials: creds,
+		Cache:       c,
+		now:         time.Now,
 	}
-	err := client.LoginPassword(sfUser, sfPassword, sfToken)
+}
+
+// Query matches the search text against website, platform and tracking code
+func (s *DAOImpl) Query(text string) ([]*models.AccountInfo, error) {
+	return s.Search(context.Background(), &search.Query{Text: text})
+}
+
+// fields maps search fields onto Account fields, active is handled separately as it comes from Type
+var fields = map[search.Field]string{
+	search.Platform:    "Platform__c",
+	search.CSM:         "CS_Manager__r.Name",
+	search.State:       "BillingState",
+	search.City:        "BillingCity",
+	search.MRR:         "Chargify_MRR__c",
+	search.FamilyMRR:   "Family_MRR__c",
+	search.Integration: "Integration_Type__c",
+	search.Provider:    "Chargify_Source__c",
+	search.Site:        "Tracking_Code__c",
+	search.Website:     "Website",
+}
+
+// unboundedSearchLimit caps searches with neither text nor a condition Salesforce can
+// filter on, e.g. only active:false, which would otherwise return most of the accounts
+const unboundedSearchLimit = 200
+
+// Search returns the accounts matching a parsed search, the query is abandoned when ctx is done
+func (s *DAOImpl) Search(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error) {
+	soql, remaining := searchSOQL(query)
+	accounts, err := s.queryAccounts(ctx, soql)
 	if err != nil {
-		log.Println(err.Error())
-		return nil
+		return nil, err
 	}
-	return &DAOImpl{
-		Client: client,
+	return remaining.Filter(accounts), nil
+}
+
+// searchSOQL builds the query for a search, the conditions Salesforce has no column for
+// are returned to be checked once the results are back
+func searchSOQL(query *search.Query) (string, *search.Query) {
+	text := domains.SearchTerm(query.Text)
+	builder := qb.Select(qb.SOQL, selectFields).From("Account")
+	if text != "" {
+		builder.Where(qb.Or(
+			qb.Contains("Website", text),
+			qb.Contains("Platform__c", text),
+			qb.Equals("Tracking_Code__c", text),
+		))
+	}
+	bounded := text != ""
+	remaining := &search.Query{}
+	for _, c := range query.Conditions {
+		if c.Field == search.Active {
+			if c.Bool != (c.Op == search.NotEqual) {
+				builder.Where(qb.Equals("Type", "Customer"))
+				bounded = true
+			} else {
+				builder.Where(qb.NotEquals("Type", "Customer"))
+			}
+			continue
+		}
+		field, ok := fields[c.Field]
+		if !ok {
+			remaining.Conditions = append(remaining.Conditions, c)
+			continue
+		}
+		builder.Where(c.Where(field))
+		bounded = true
+	}
+	builder.OrderBy("Chargify_MRR__c DESC")
+	if !bounded {
+		builder.Limit(unboundedSearchLimit)
+	}
+	return builder.String(), remaining
+}
+
+// QueryCustomers returns every account with a customer type, following Salesforce's result pages
+func (s *DAOImpl) QueryCustomers() ([]*models.AccountInfo, error) {
+	return s.queryAccounts(context.Background(), qb.Select(qb.SOQL, selectFields).From("Account").
+		Where(qb.Equals("Type", "Customer")).
+		OrderBy("Chargify_MRR__c DESC").String())
+}
+
+// Profile returns the accounts with the tracking code or website key, with the fields
+// for the detailed view. Accounts of any type are returned and the website only has
+// to contain key, callers pick the exact match.
+func (s *DAOImpl) Profile(key string) ([]*models.AccountInfo, error) {
+	key = strings.TrimSpace(key)
+	return s.queryAccounts(context.Background(), qb.Select(qb.SOQL, profileFields).From("Account").
+		Where(qb.Or(
+			qb.Equals("Tracking_Code__c", key),
+			qb.Contains("Website", domains.SearchTerm(key)),
+		)).
+		OrderBy("Chargify_MRR__c DESC").String())
+}
+
+// queryAccounts runs the query following Salesforce's result pages. Accounts for a
+// query that ran in the last few minutes come from the cache.
+func (s *DAOImpl) queryAccounts(ctx context.Context, soql string) ([]*models.AccountInfo, error) {
+	key := cache.Key("salesforce", soql)
+	accounts := []*models.AccountInfo{}
+	if s.Cache.GetJSON(key, &accounts) {
+		return accounts, nil
 	}
+	q := soql
+	for {
+		result, err := s.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		page, err := s.ResultToMessage("", result)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, page...)
+		if result.Done || result.NextRecordsURL == "" {
+			break
+		}
+		q = result.NextRecordsURL
+	}
+	s.Cache.SetJSON(key, accounts, queryTTL)
+	return accounts, nil
 }
 
-func (s *DAOImpl) Query(search string) ([]*models.AccountInfo, error) {
-	reg, err := regexp.Compile("[^a-zA-Z0-9_.-]+")
+// query runs q with the session, logging in first if there isn't one and again if the
+// session has expired
+func (s *DAOImpl) query(ctx context.Context, q string) (*simpleforce.QueryResult, error) {
+	sess, err := s.currentSession(nil)
 	if err != nil {
 		return nil, err
 	}
+	result, err := sess.query(ctx, s.Client, q)
+	if invalidSession(err) {
+		log.Println("salesforce session expired, logging in again")
+		if sess, err = s.currentSession(sess); err != nil {
+			return nil, err
+		}
+		result, err = sess.query(ctx, s.Client, q)
+	}
+	return result, err
+}
 
-	sanitized := reg.ReplaceAllString(search, "")
-
-	q := "SELECT " + selectFields + " " +
-		"FROM Account WHERE (Website LIKE '%" + sanitized + "%' OR Platform__c LIKE '%" + sanitized +
-		"%' OR Tracking_Code__c = '" + sanitized + "') ORDER BY Chargify_MRR__c DESC"
-	result, err := s.Client.Query(q)
+// currentSession returns the session, logging in when there is none or it is still the
+// expired one. Concurrent queries that find the same expired session only log in once.
+func (s *DAOImpl) currentSession(expired *session) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session != nil && s.session != expired {
+		return s.session, nil
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	sess, err := login(s.Client, s.Credentials, now())
 	if err != nil {
+		s.session = nil
 		return nil, err
 	}
-	return s.ResultToMessage(sanitized, result)
+	s.session = sess
+	return sess, nil
 }
 
 func (s *DAOImpl) ResultToMessage(search string, result *simpleforce.QueryResult) ([]*models.AccountInfo, error) {
 	accounts := []*models.AccountInfo{}
 	for _, record := range result.Records {
-		manager := record["CS_Manager__r"]
-		managerName := "unknown"
-		if manager != nil {
-			if mapName, ok := (manager.(map[string]interface{}))["Name"]; ok {
-				managerName = fmt.Sprintf("%s", mapName)
-			}
+		managerName := relationName(record, "CS_Manager__r")
+		if managerName == "" {
+			managerName = "unknown"
+		}
+		Type := ""
+		if record["Type"] != nil {
+			Type = fmt.Sprintf("%s", record["Type"])
 		}
-		Type := fmt.Sprintf("%s", record["Type"])
 		active := "Active"
 		if Type != "Customer" {
 			active = "Not active"
 		}
+		website := "unknown"
+		if record["Website"] != nil {
+			website = fmt.Sprintf("%s", record["Website"])
+		}
 		platform := "unknown"
 		if record["Platform__c"] != nil {
 			platform = fmt.Sprintf("%s", record["Platform__c"])
@@ -108,25 +274,53 @@ func (s *DAOImpl) ResultToMessage(search string, result *simpleforce.QueryResult
 			state = fmt.Sprintf("%s", record["BillingState"])
 		}
 
+		salesforceId := ""
+		if record["Id"] != nil {
+			salesforceId = fmt.Sprintf("%s", record["Id"])
+		}
+		country := ""
+		if record["BillingCountry"] != nil {
+			country = fmt.Sprintf("%s", record["BillingCountry"])
+		}
+		created := time.Time{}
+		if date, ok := record["CreatedDate"].(string); ok {
+			created, _ = time.Parse(createdLayout, date)
+		}
+
 		accounts = append(accounts, &models.AccountInfo{
-			Website:     fmt.Sprintf("%s", record["Website"]),
-			Manager:     managerName,
-			Active:      active,
-			Type:        Type,
-			MRR:         mrr,
-			FamilyMRR:   familymrr,
-			Platform:    platform,
-			Integration: integration,
-			Provider:    provider,
-			SiteId:      siteId,
-			City:        city,
-			State:       state,
+			Website:      website,
+			Manager:      managerName,
+			Active:       active,
+			Type:         Type,
+			MRR:          mrr,
+			FamilyMRR:    familymrr,
+			Platform:     platform,
+			Integration:  integration,
+			Provider:     provider,
+			SiteId:       siteId,
+			City:         city,
+			State:        state,
+			SalesforceId: salesforceId,
+			Country:      country,
+			Owner:        relationName(record, "Owner"),
+			Created:      created,
+			Parent:       relationName(record, "Parent"),
 		})
 	}
 
 	return accounts, nil
 }
 
+// relationName returns the Name of a related record, e.g. Owner.Name, or "" when the
+// relation is empty or wasn't selected
+func relationName(record simpleforce.SObject, relation string) string {
+	related, ok := record[relation].(map[string]interface{})
+	if !ok || related["Name"] == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s", related["Name"])
+}
+
 func (s *DAOImpl) GetSearchKey() string {
 	return ""
 }
@@ -2,10 +2,13 @@ package salesforce
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/domains"
 	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
 	"github.com/simpleforce/simpleforce"
 	"github.com/stretchr/testify/require"
 )
@@ -15,6 +18,7 @@ func createQueryResults() *simpleforce.QueryResult {
 	json.Unmarshal([]byte(`{ "totalSize": 1,
         "done": true,
         "records": [{ 
+                "Id": "0015000000abcDE",
                 "Website": "fabletics.com",
                 "CS_Manager__r": { "Name": "Ashley Hilton" },
                 "Family_MRR__c": 14858.54,
@@ -30,6 +34,32 @@ func createQueryResults() *simpleforce.QueryResult {
 	return qr
 }
 
+func TestResultToMessageProfileFields(t *testing.T) {
+	qr := &simpleforce.QueryResult{}
+	json.Unmarshal([]byte(`{"totalSize": 1, "done": true, "records": [{
+		"Id": "0015000000abcDE",
+		"Type": "Customer",
+		"Website": "fabletics.com",
+		"BillingCountry": "United States",
+		"Owner": {"Name": "Sam Seller"},
+		"CreatedDate": "2019-03-04T17:21:45.000+0000",
+		"Parent": {"Name": "TechStyle"}
+	}]}`), qr)
+	response, err := (&DAOImpl{}).ResultToMessage("", qr)
+	require.NoError(t, err)
+	require.Equal(t, "United States", response[0].Country)
+	require.Equal(t, "Sam Seller", response[0].Owner)
+	require.Equal(t, "TechStyle", response[0].Parent)
+	require.Equal(t, "2019-03-04", response[0].Created.Format("2006-01-02"))
+	require.Equal(t, "unknown", response[0].Manager)
+
+	// the summary query doesn't select the owner or parent
+	response, err = (&DAOImpl{}).ResultToMessage("", createQueryResults())
+	require.NoError(t, err)
+	require.Equal(t, "", response[0].Owner)
+	require.True(t, response[0].Created.IsZero())
+}
+
 func TestResultToMessage(t *testing.T) {
 	dao := &DAOImpl{}
 	response, err := dao.ResultToMessage("search term", createQueryResults())
@@ -45,6 +75,23 @@ func TestResultToMessage(t *testing.T) {
 	require.Contains(t, response[0].SiteId, "wub9gl")
 	require.Contains(t, response[0].City, "Chicago")
 	require.Contains(t, response[0].State, "IL")
+	require.Equal(t, "0015000000abcDE", response[0].SalesforceId)
+}
+
+func TestResultToMessageWithoutWebsite(t *testing.T) {
+	qr := &simpleforce.QueryResult{}
+	require.NoError(t, json.Unmarshal([]byte(`{"totalSize": 2, "done": true, "records": [
+		{"Id": "0015000000abcDE", "Website": null, "Type": null},
+		{"Id": "0015000000fghIJ", "Website": null, "Type": "Customer"}
+	]}`), qr))
+	dao := &DAOImpl{}
+	response, err := dao.ResultToMessage("search term", qr)
+	require.NoError(t, err)
+	require.Equal(t, "unknown", response[0].Website)
+	require.Equal(t, "", response[0].Type)
+	require.Equal(t, "Not active", response[0].Active)
+	// accounts without a website have no host so they are never the same account
+	require.False(t, domains.Same(response[0].Website, response[1].Website))
 }
 
 func TestFormatAccountInfos(t *testing.T) {
@@ -75,3 +122,18 @@ func TestFormatAccountInfos(t *testing.T) {
 	require.Equal(t, "fabletics.com (Not active) (SiteId: wub9gl)", msg.Attachments[0].AuthorName)
 	require.Equal(t, "#3A23AD", msg.Attachments[0].Color)
 }
+
+func TestSearchSOQLLimitsUnboundedSearches(t *testing.T) {
+	for text, limited := range map[string]bool{
+		"active:false":          true,
+		"shoes active:false":    false,
+		"platform:shopify":      false,
+		"active:true":           false,
+		"active:false state:CO": false,
+	} {
+		query, err := search.Parse(text)
+		require.NoError(t, err)
+		soql, _ := searchSOQL(query)
+		require.Equal(t, limited, strings.HasSuffix(soql, " LIMIT 200"), text)
+	}
+}
@@ -0,0 +1,295 @@
+package salesforce
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/simpleforce/simpleforce"
+)
+
+// Credentials log in to Salesforce with either a username, password and security token
+// or, when ClientID is set, the OAuth JWT bearer flow with the connected app's key
+type Credentials struct {
+	URL        string
+	User       string
+	Password   string
+	Token      string
+	ClientID   string
+	PrivateKey string
+}
+
+// NewCredentials reads the Salesforce credentials from the environment
+func NewCredentials(env common.EnvVars) Credentials {
+	return Credentials{
+		URL:        env.SfURL,
+		User:       env.SfUser,
+		Password:   env.SfPassword,
+		Token:      env.SfToken,
+		ClientID:   env.SfClientId,
+		PrivateKey: env.SfPrivateKey,
+	}
+}
+
+// Configured reports whether either login flow has everything it needs
+func (c Credentials) Configured() bool {
+	if c.ClientID != "" {
+		return !common.ContainsEmptyString(c.URL, c.User, c.PrivateKey)
+	}
+	return !common.ContainsEmptyString(c.URL, c.User, c.Password, c.Token)
+}
+
+// jwtLifetime is how long a JWT bearer assertion is valid for, Salesforce allows 3 minutes
+const jwtLifetime = 3 * time.Minute
+
+// LoginError is returned when Salesforce refuses to log in, Reason is Salesforce's explanation
+type LoginError struct {
+	Reason string
+}
+
+func (e *LoginError) Error() string {
+	return "salesforce login failed: " + e.Reason
+}
+
+// APIError is an error response from the REST API
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("salesforce query failed - status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("salesforce query failed - status code: %d, %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// invalidSession reports whether err means the session expired or was revoked
+func invalidSession(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Code == "INVALID_SESSION_ID" || apiErr.StatusCode == http.StatusUnauthorized)
+}
+
+// session is a logged in Salesforce session
+type session struct {
+	AccessToken string
+	InstanceURL string
+}
+
+// login starts a session with the JWT bearer flow when there's a client id, otherwise
+// with the SOAP login the username, password and security token work with
+func login(client common.HTTPClient, creds Credentials, now time.Time) (*session, error) {
+	if creds.ClientID != "" {
+		return jwtLogin(client, creds, now)
+	}
+	return passwordLogin(client, creds)
+}
+
+const soapLogin = `<?xml version="1.0" encoding="utf-8" ?>
+<env:Envelope xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:env="http://schemas.xmlsoap.org/soap/envelope/" xmlns:urn="urn:partner.soap.sforce.com">
+	<env:Header>
+		<urn:CallOptions>
+			<urn:client>%s</urn:client>
+			<urn:defaultNamespace>sf</urn:defaultNamespace>
+		</urn:CallOptions>
+	</env:Header>
+	<env:Body>
+		<n1:login xmlns:n1="urn:partner.soap.sforce.com">
+			<n1:username>%s</n1:username>
+			<n1:password>%s%s</n1:password>
+		</n1:login>
+	</env:Body>
+</env:Envelope>`
+
+func passwordLogin(client common.HTTPClient, creds Credentials) (*session, error) {
+	body := fmt.Sprintf(soapLogin, simpleforce.DefaultClientID, html.EscapeString(creds.User), html.EscapeString(creds.Password), html.EscapeString(creds.Token))
+	req, err := http.NewRequest(http.MethodPost, loginURL(creds, "/services/Soap/u/"+simpleforce.DefaultAPIVersion), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=UTF-8")
+	req.Header.Set("SOAPAction", "login")
+	data, status, err := do(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		ServerURL   string `xml:"Body>loginResponse>result>serverUrl"`
+		SessionID   string `xml:"Body>loginResponse>result>sessionId"`
+		FaultString string `xml:"Body>Fault>faultstring"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil && status == http.StatusOK {
+		return nil, err
+	}
+	if response.FaultString != "" {
+		return nil, &LoginError{Reason: response.FaultString}
+	}
+	if status != http.StatusOK || response.SessionID == "" {
+		return nil, &LoginError{Reason: fmt.Sprintf("status code: %d", status)}
+	}
+	serverURL, err := url.Parse(response.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		AccessToken: response.SessionID,
+		InstanceURL: serverURL.Scheme + "://" + serverURL.Host,
+	}, nil
+}
+
+func jwtLogin(client common.HTTPClient, creds Credentials, now time.Time) (*session, error) {
+	assertion, err := signJWT(creds, now)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequest(http.MethodPost, loginURL(creds, "/services/oauth2/token"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	data, status, err := do(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		AccessToken      string `json:"access_token"`
+		InstanceURL      string `json:"instance_url"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil && status == http.StatusOK {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, &LoginError{Reason: response.Error + ": " + response.ErrorDescription}
+	}
+	if status != http.StatusOK || response.AccessToken == "" {
+		return nil, &LoginError{Reason: fmt.Sprintf("status code: %d", status)}
+	}
+	return &session{
+		AccessToken: response.AccessToken,
+		InstanceURL: strings.TrimRight(response.InstanceURL, "/"),
+	}, nil
+}
+
+// signJWT creates the RS256 signed assertion for the JWT bearer flow, the audience is
+// the login URL so sandboxes log in with https://test.salesforce.com
+func signJWT(creds Credentials, now time.Time) (string, error) {
+	key, err := parsePrivateKey(creds.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": creds.ClientID,
+		"sub": creds.User,
+		"aud": strings.TrimRight(creds.URL, "/"),
+		"exp": now.Add(jwtLifetime).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey reads a PEM encoded PKCS#1 or PKCS#8 RSA key, env vars often hold the
+// key with escaped newlines or base64 encoded so both are accepted
+func parsePrivateKey(key string) (*rsa.PrivateKey, error) {
+	key = strings.ReplaceAll(strings.TrimSpace(key), `\n`, "\n")
+	if !strings.HasPrefix(key, "-----") {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, errors.New("salesforce private key is not PEM or base64 encoded PEM")
+		}
+		key = string(decoded)
+	}
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("salesforce private key is not PEM encoded")
+	}
+	if rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return rsaKey, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("salesforce private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// query runs SOQL, or fetches the next page when q is a nextRecordsUrl, with the session
+func (s *session) query(ctx context.Context, client common.HTTPClient, q string) (*simpleforce.QueryResult, error) {
+	u := s.InstanceURL + q
+	if !strings.HasPrefix(q, "/services/data") {
+		u = s.InstanceURL + "/services/data/v" + simpleforce.DefaultAPIVersion + "/query?q=" + url.QueryEscape(q)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	data, status, err := do(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		apiErr := &APIError{StatusCode: status}
+		errs := []struct {
+			ErrorCode string `json:"errorCode"`
+			Message   string `json:"message"`
+		}{}
+		if json.Unmarshal(data, &errs) == nil && len(errs) > 0 {
+			apiErr.Code = errs[0].ErrorCode
+			apiErr.Message = errs[0].Message
+		}
+		return nil, apiErr
+	}
+	result := &simpleforce.QueryResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func loginURL(creds Credentials, path string) string {
+	return strings.TrimRight(creds.URL, "/") + path
+}
+
+func do(client common.HTTPClient, req *http.Request) ([]byte, int, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	return data, res.StatusCode, err
+}
@@ -0,0 +1,182 @@
+package salesforce
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const loginResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns="urn:partner.soap.sforce.com">
+	<soapenv:Body><loginResponse><result>
+		<serverUrl>%s/services/Soap/u/43.0/00D000000000001</serverUrl>
+		<sessionId>%s</sessionId>
+	</result></loginResponse></soapenv:Body>
+</soapenv:Envelope>`
+
+const loginFault = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:sf="urn:fault.partner.soap.sforce.com">
+	<soapenv:Body><soapenv:Fault>
+		<faultcode>sf:INVALID_LOGIN</faultcode>
+		<faultstring>INVALID_LOGIN: Invalid username, password, security token; or user locked out.</faultstring>
+	</soapenv:Fault></soapenv:Body>
+</soapenv:Envelope>`
+
+// fakeSalesforce logs in with the SOAP login and answers queries with a single account,
+// sessions become invalid when expire is called
+type fakeSalesforce struct {
+	*httptest.Server
+	logins  int
+	queries []string
+	session string
+}
+
+func newFakeSalesforce(t *testing.T) *fakeSalesforce {
+	f := &fakeSalesforce{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/services/Soap/u/"):
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), "<n1:password>secrettoken</n1:password>") {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(loginFault))
+				return
+			}
+			f.logins++
+			f.session = fmt.Sprintf("session%d", f.logins)
+			fmt.Fprintf(w, loginResponse, f.URL, f.session)
+		case strings.HasPrefix(r.URL.Path, "/services/data/"):
+			if r.Header.Get("Authorization") != "Bearer "+f.session {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`[{"message":"Session expired or invalid","errorCode":"INVALID_SESSION_ID"}]`))
+				return
+			}
+			f.queries = append(f.queries, r.URL.RequestURI())
+			if r.URL.Path == "/services/data/v43.0/query/next" {
+				w.Write([]byte(`{"totalSize":2,"done":true,"records":[{"Id":"0015000000abcDF","Website":"two.com","Type":"Customer"}]}`))
+				return
+			}
+			w.Write([]byte(`{"totalSize":2,"done":false,"nextRecordsUrl":"/services/data/v43.0/query/next","records":[{"Id":"0015000000abcDE","Website":"one.com","Type":"Customer"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSalesforce) expire() {
+	f.session = "expired"
+}
+
+func newTestDAO(url string, password string) *DAOImpl {
+	return NewDAO(Credentials{URL: url, User: "nebo@searchspring.com", Password: password, Token: "token"}, nil).(*DAOImpl)
+}
+
+func TestQueryKeepsSession(t *testing.T) {
+	f := newFakeSalesforce(t)
+	dao := newTestDAO(f.URL, "secret")
+
+	accounts, err := dao.QueryCustomers()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(accounts))
+	require.Equal(t, "one.com", accounts[0].Website)
+	require.Equal(t, "two.com", accounts[1].Website)
+	require.Contains(t, f.queries[0], "/services/data/v43.0/query?q=SELECT+Id")
+
+	_, err = dao.Query("one")
+	require.NoError(t, err)
+	require.Equal(t, 1, f.logins)
+}
+
+func TestQueryLogsInAgainWhenSessionExpires(t *testing.T) {
+	f := newFakeSalesforce(t)
+	dao := newTestDAO(f.URL, "secret")
+
+	_, err := dao.Query("one")
+	require.NoError(t, err)
+	f.expire()
+	accounts, err := dao.Query("two")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(accounts))
+	require.Equal(t, 2, f.logins)
+}
+
+func TestQueryReportsLoginFailure(t *testing.T) {
+	f := newFakeSalesforce(t)
+	dao := newTestDAO(f.URL, "wrong")
+
+	_, err := dao.Query("one")
+	var loginErr *LoginError
+	require.ErrorAs(t, err, &loginErr)
+	require.Equal(t, "salesforce login failed: INVALID_LOGIN: Invalid username, password, security token; or user locked out.", err.Error())
+	require.Empty(t, f.queries)
+}
+
+func TestJWTLogin(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	now := time.Unix(1600000000, 0)
+
+	var claims map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/services/oauth2/token", r.URL.Path)
+		require.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.FormValue("grant_type"))
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		require.Equal(t, 3, len(parts))
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature))
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		w.Write([]byte(`{"access_token":"jwt-session","instance_url":"https://searchspring.my.salesforce.com"}`))
+	}))
+	defer server.Close()
+
+	creds := Credentials{URL: server.URL, User: "nebo@searchspring.com", ClientID: "consumer-key", PrivateKey: strings.ReplaceAll(string(privateKey), "\n", `\n`)}
+	require.True(t, creds.Configured())
+	sess, err := login(server.Client(), creds, now)
+	require.NoError(t, err)
+	require.Equal(t, &session{AccessToken: "jwt-session", InstanceURL: "https://searchspring.my.salesforce.com"}, sess)
+	require.Equal(t, "consumer-key", claims["iss"])
+	require.Equal(t, "nebo@searchspring.com", claims["sub"])
+	require.Equal(t, server.URL, claims["aud"])
+	require.Equal(t, float64(now.Add(jwtLifetime).Unix()), claims["exp"])
+}
+
+func TestJWTLoginError(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"user hasn't approved this consumer"}`))
+	}))
+	defer server.Close()
+
+	creds := Credentials{URL: server.URL, User: "nebo@searchspring.com", ClientID: "consumer-key", PrivateKey: base64.StdEncoding.EncodeToString(privateKey)}
+	_, err = login(server.Client(), creds, time.Now())
+	require.EqualError(t, err, "salesforce login failed: invalid_grant: user hasn't approved this consumer")
+}
+
+func TestCredentialsConfigured(t *testing.T) {
+	require.True(t, Credentials{URL: "url", User: "user", Password: "password", Token: "token"}.Configured())
+	require.False(t, Credentials{URL: "url", User: "user", Password: "password"}.Configured())
+	require.False(t, Credentials{URL: "url", User: "user", ClientID: "client"}.Configured())
+	require.Nil(t, NewDAO(Credentials{}, nil))
+}
@@ -0,0 +1,122 @@
+// Package domains compares the websites each system stores for an account. Metabase,
+// Salesforce and Nextopia write them differently, with or without a scheme, www., a
+// path or upper case letters, so websites are reduced to their canonical host before
+// they are compared. Score ranks websites against a search allowing for a typo or two.
+package domains
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+var wwwPrefix = regexp.MustCompile(`^www\d*\.`)
+
+// Canonical returns the host of a website or URL as lower case ASCII, internationalised
+// names in punycode, without the scheme, credentials, port, path, trailing dot or a
+// leading www. It returns "" for a blank or "unknown" website.
+func Canonical(website string) string {
+	s := strings.TrimSpace(website)
+	if s == "" || strings.EqualFold(s, "unknown") {
+		return ""
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	host := ""
+	if u, err := url.Parse(s); err == nil {
+		host = u.Hostname()
+	} else {
+		// not a valid URL, cut it up by hand
+		host = s[strings.Index(s, "://")+3:]
+		if i := strings.IndexAny(host, "/?#"); i >= 0 {
+			host = host[:i]
+		}
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	if stripped := wwwPrefix.ReplaceAllString(host, ""); strings.Contains(stripped, ".") {
+		host = stripped
+	}
+	return host
+}
+
+// Registrable returns the domain the website is registered under, its public suffix and
+// one more label, so shop.example.co.uk is example.co.uk. Hosts without a known public
+// suffix, like IP addresses, are returned as they are.
+func Registrable(website string) string {
+	host := Canonical(website)
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// Name returns the registrable domain without its public suffix, example for
+// shop.example.co.uk
+func Name(website string) string {
+	domain := Registrable(website)
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	if suffix == domain {
+		return domain
+	}
+	return strings.TrimSuffix(domain, "."+suffix)
+}
+
+// Same reports whether two websites have the same canonical host
+func Same(a string, b string) bool {
+	host := Canonical(a)
+	return host != "" && host == Canonical(b)
+}
+
+// IsDomain reports whether text is a website rather than search words, it must be a
+// single word whose host ends in a known public suffix
+func IsDomain(text string) bool {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.ContainsAny(text, " \t") {
+		return false
+	}
+	host := Canonical(text)
+	if !strings.Contains(host, ".") || net.ParseIP(host) != nil {
+		return false
+	}
+	suffix, icann := publicsuffix.PublicSuffix(host)
+	return suffix != host && (icann || strings.Contains(suffix, "."))
+}
+
+// SearchTerm returns the text to search the systems for, the canonical host when the
+// text is a website so www.Shoes.com/store searches for shoes.com
+func SearchTerm(text string) string {
+	text = strings.TrimSpace(text)
+	if IsDomain(text) {
+		return Canonical(text)
+	}
+	return text
+}
+
+// Compact lower cases s and keeps only its letters and digits, so the Nextopia name
+// ec_123djcom can be compared with 123dj.com
+func Compact(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
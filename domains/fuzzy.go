@@ -0,0 +1,121 @@
+package domains
+
+import "strings"
+
+// MinScore is the lowest Score that counts as a match
+const MinScore = 0.3
+
+// Score rates how well a website matches a search from 0, not at all, to 1, the same
+// host. Websites on the same registrable domain come next, then websites containing
+// the search, then names a typo or two away from it or with most of its trigrams.
+func Score(query string, website string) float64 {
+	query = strings.TrimSpace(query)
+	host := Canonical(website)
+	if query == "" || host == "" {
+		return 0
+	}
+	domain := IsDomain(query)
+	if domain {
+		if Same(query, website) {
+			return 1
+		}
+		if Registrable(query) == Registrable(website) {
+			return 0.9
+		}
+	}
+
+	term := Compact(SearchTerm(query))
+	compactHost := Compact(host)
+	if term == "" || compactHost == "" {
+		return 0
+	}
+	if strings.Contains(compactHost, term) {
+		return 0.5 + 0.3*float64(len(term))/float64(len(compactHost))
+	}
+
+	name := term
+	if domain {
+		name = Compact(Name(query))
+	}
+	websiteName := Compact(Name(website))
+	if d := Distance(name, websiteName); d <= allowedTypos(name) {
+		return 0.5 - 0.1*float64(d)
+	}
+	if s := Similarity(name, websiteName); s >= 0.5 {
+		return 0.6 * s
+	}
+	return 0
+}
+
+// allowedTypos is how many edits a name can be from the search, none for short names
+// which would match too much
+func allowedTypos(name string) int {
+	switch n := len([]rune(name)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// Distance is the Levenshtein distance between a and b, the number of single letter
+// insertions, deletions and substitutions that turn one into the other
+func Distance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// Similarity is the share of trigrams a and b have in common from 0 to 1, the words
+// are padded so their first and last letters count as much as the middle ones
+func Similarity(a string, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(s string) map[string]bool {
+	r := []rune("  " + s + " ")
+	set := map[string]bool{}
+	if len(r) == 3 {
+		return set
+	}
+	for i := 0; i+3 <= len(r); i++ {
+		set[string(r[i:i+3])] = true
+	}
+	return set
+}
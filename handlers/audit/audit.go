@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/searchspring/nebo/cache"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/google"
+	"github.com/searchspring/nebo/services/aggregate"
+	"github.com/searchspring/nebo/services/commands"
+)
+
+var router *mux.Router
+var env common.EnvVars
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	err := envconfig.Process("", &env)
+	if err != nil {
+		common.SendInternalServerError(w, err)
+		return
+	}
+
+	blanks := common.FindBlankEnvVars(env)
+	if len(blanks) > 0 {
+		err := fmt.Errorf("the following env vars are blank: %s", strings.Join(blanks, ", "))
+		if env.DevMode != "development" {
+			common.SendInternalServerError(w, err)
+			return
+		}
+		log.Println(err.Error())
+	}
+
+	if router == nil {
+		r, err := CreateRouter()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		router = r
+	}
+	router.ServeHTTP(w, r)
+}
+
+func CreateRouter() (*mux.Router, error) {
+	router := mux.NewRouter()
+	AddRoutes(router, env)
+	router.Use(mux.CORSMethodMiddleware(router))
+	return router, nil
+}
+
+// AddRoutes registers /audit on router, the audit uses the DAOs shared by the process
+func AddRoutes(router *mux.Router, env common.EnvVars) {
+	googleDAO := google.NewDAO(&http.Client{}, cache.Shared(env.CacheURL), google.NewPolicy(env))
+	addRoutes(router, env, googleDAO.CheckUserLoggedIn)
+}
+
+func addRoutes(router *mux.Router, env common.EnvVars, checkUserLoggedIn func(string) (string, error)) {
+	router.HandleFunc("/audit", func(w http.ResponseWriter, r *http.Request) {
+		runner := commands.Shared(env)
+		auditService := &aggregate.AuditServiceImpl{
+			MetabaseDAO:   runner.MetabaseDAO,
+			SalesforceDAO: runner.SalesforceDAO,
+		}
+		common.WithAuthorizedCheck(checkUserLoggedIn, func(w http.ResponseWriter, r *http.Request) {
+			GetAuditReport(w, r, auditService)
+		})(w, r)
+	}).Methods(http.MethodGet, http.MethodOptions)
+}
+
+// GetAuditReport responds with the full Salesforce vs Metabase audit as JSON
+func GetAuditReport(w http.ResponseWriter, r *http.Request, auditService aggregate.AuditService) {
+	report, err := auditService.Audit()
+	if err != nil {
+		common.SendInternalServerError(w, err)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		common.SendInternalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
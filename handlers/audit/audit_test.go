@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/google"
+	"github.com/searchspring/nebo/mocks"
+	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/services/aggregate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAuditReport(t *testing.T) {
+	w := httptest.NewRecorder()
+	auditService := &aggregate.AuditServiceImpl{
+		MetabaseDAO:   &mocks.MetabaseDAO{Accounts: []*models.AccountInfo{{SiteId: "abc123", Website: "one.com", MRR: 10}}},
+		SalesforceDAO: &mocks.SalesforceDAO{Accounts: []*models.AccountInfo{{SiteId: "abc123", Website: "one.com", MRR: 12}}},
+	}
+	GetAuditReport(w, httptest.NewRequest("GET", "localhost:3000/audit", nil), auditService)
+	require.Equal(t, 200, w.Result().StatusCode)
+	report := &aggregate.AuditReport{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), report))
+	require.Equal(t, 1, len(report.MRRMismatches))
+}
+
+func TestGetAuditReportError(t *testing.T) {
+	w := httptest.NewRecorder()
+	auditService := &aggregate.AuditServiceImpl{
+		MetabaseDAO:   &mocks.MetabaseDAO{Err: errors.New("boom")},
+		SalesforceDAO: &mocks.SalesforceDAO{},
+	}
+	GetAuditReport(w, httptest.NewRequest("GET", "localhost:3000/audit", nil), auditService)
+	require.Equal(t, 500, w.Result().StatusCode)
+}
+
+func TestUnauthorizedDomain(t *testing.T) {
+	w := httptest.NewRecorder()
+	check := func(token string) (string, error) {
+		return "", fmt.Errorf("%w: must have a searchspring.com email address", google.ErrForbidden)
+	}
+	router := mux.NewRouter()
+	addRoutes(router, common.EnvVars{}, check)
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/audit", nil))
+	require.Equal(t, 403, w.Result().StatusCode)
+}
+
+func TestPreflightAllowsAuthorization(t *testing.T) {
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	addRoutes(router, common.EnvVars{}, func(token string) (string, error) { return "", errors.New("no token") })
+	router.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/audit", nil))
+	require.Equal(t, 200, w.Result().StatusCode)
+	require.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+}
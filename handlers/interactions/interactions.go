@@ -0,0 +1,142 @@
+package interactions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nlopes/slack"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/services/commands"
+)
+
+// pageRunner re-runs a command for another page of results
+type pageRunner interface {
+	Run(ctx context.Context, page common.Page) (*slack.Msg, error)
+}
+
+// Handler receives block actions, modal submissions and shortcuts from Slack
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var env common.EnvVars
+	err := envconfig.Process("", &env)
+	if err != nil {
+		common.SendInternalServerError(w, err)
+		return
+	}
+
+	blanks := common.FindBlankEnvVars(env)
+	if len(blanks) > 0 {
+		err := fmt.Errorf("the following env vars are blank: %s", strings.Join(blanks, ", "))
+		if env.DevMode != "development" {
+			common.SendInternalServerError(w, err)
+			return
+		}
+		log.Println(err.Error())
+	}
+
+	// the function is frozen once it answers, pages are fetched by a second invocation
+	NewHandler(env, common.NewSelfInvoke()).ServeHTTP(w, r)
+}
+
+// NewHandler returns the interactions handler for env, requests are verified as coming
+// from Slack. Pages are fetched by background after the click is acknowledged.
+func NewHandler(env common.EnvVars, background common.Background) http.Handler {
+	return background.Wrap(common.NewSlackVerifier(env).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		newRouter(commands.Shared(env), background).ServeHTTP(w, r)
+	})))
+}
+
+// newRouter registers a handler for each button, modal and shortcut nebo offers
+func newRouter(runner pageRunner, background common.Background) *common.InteractionRouter {
+	router := common.NewInteractionRouter()
+	paging := func(w http.ResponseWriter, r *http.Request, payload *common.InteractionPayload) {
+		handlePaging(w, r, payload, runner, background)
+	}
+	router.Action(common.NextPageActionID, paging)
+	router.Action(common.PreviousPageActionID, paging)
+	router.Action(common.CopySiteIDActionID, handleCopySiteID)
+	// link buttons open in the browser, slack still tells us about the click
+	for _, actionID := range common.LinkActionIDs {
+		router.Action(actionID, acknowledge)
+	}
+	return router
+}
+
+func acknowledge(w http.ResponseWriter, r *http.Request, payload *common.InteractionPayload) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleCopySiteID posts the site id on its own to the user, Slack has no way to
+// write to the clipboard but a lone code span selects with a double click
+func handleCopySiteID(w http.ResponseWriter, r *http.Request, payload *common.InteractionPayload) {
+	action, _ := payload.Action(common.CopySiteIDActionID)
+	w.WriteHeader(http.StatusOK)
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         "`" + action.Value + "`",
+	}
+	if err := postResponse(payload.ResponseURL, msg); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func handlePaging(w http.ResponseWriter, r *http.Request, payload *common.InteractionPayload, runner pageRunner, background common.Background) {
+	action, ok := payload.Action(common.NextPageActionID)
+	if !ok {
+		action, _ = payload.Action(common.PreviousPageActionID)
+	}
+	page, err := common.ParsePage(action.Value)
+	if err != nil {
+		http.Error(w, "can't read page", http.StatusBadRequest)
+		return
+	}
+
+	// acknowledge the click and run the search once the handler has returned, slack
+	// only waits 3 seconds
+	w.WriteHeader(http.StatusOK)
+	background.Run(r, func(ctx context.Context) {
+		if err := replacePage(ctx, runner, page, payload.ResponseURL); err != nil {
+			log.Println(err.Error())
+		}
+	})
+}
+
+// replacePage runs the search for the page and replaces the original message with it
+func replacePage(ctx context.Context, runner pageRunner, page common.Page, responseURL string) error {
+	msg, err := runner.Run(ctx, page)
+	if err != nil {
+		msg = &slack.Msg{
+			ResponseType: slack.ResponseTypeEphemeral,
+			Text:         "Sorry, something went wrong: " + err.Error(),
+		}
+	} else {
+		msg.ReplaceOriginal = true
+	}
+	return postResponse(responseURL, msg)
+}
+
+func postResponse(responseURL string, msg *slack.Msg) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if responseURL == "" {
+		return errors.New("interaction has no response url")
+	}
+	res, err := http.Post(responseURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting to response url failed - status code: %d", res.StatusCode)
+	}
+	return nil
+}
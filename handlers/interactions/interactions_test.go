@@ -0,0 +1,107 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/common"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRunner struct {
+	pages []common.Page
+}
+
+func (f *fakeRunner) Run(ctx context.Context, page common.Page) (*slack.Msg, error) {
+	f.pages = append(f.pages, page)
+	return &slack.Msg{Text: "Reps for search: " + page.Text}, nil
+}
+
+// blockingRunner waits for release before running the page, the request context is
+// cancelled by then
+type blockingRunner struct {
+	fakeRunner
+	release chan struct{}
+}
+
+func (b *blockingRunner) Run(ctx context.Context, page common.Page) (*slack.Msg, error) {
+	<-b.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.fakeRunner.Run(ctx, page)
+}
+
+func interactionRequest(t *testing.T, payload string) *http.Request {
+	form := url.Values{"payload": {payload}}
+	r := httptest.NewRequest(http.MethodPost, "/interactions", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func responseURLRecorder(posted chan []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted <- body
+	}))
+}
+
+func TestNextPage(t *testing.T) {
+	posted := make(chan []byte, 1)
+	server := responseURLRecorder(posted)
+	defer server.Close()
+
+	value, _ := json.Marshal(common.Page{Command: "/nebo", Text: "shoes", Offset: 20})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": server.URL,
+		"actions":      []map[string]string{{"block_id": "paging", "action_id": common.NextPageActionID, "value": string(value)}},
+	})
+	runner := &blockingRunner{release: make(chan struct{})}
+	background := &common.Goroutines{}
+	w := httptest.NewRecorder()
+	newRouter(runner, background).ServeHTTP(w, interactionRequest(t, string(payload)))
+
+	// the click is acknowledged before the page is fetched
+	require.Equal(t, http.StatusOK, w.Code)
+	close(runner.release)
+	background.Wait()
+	require.Equal(t, []common.Page{{Command: "/nebo", Text: "shoes", Offset: 20}}, runner.pages)
+	msg := &slack.Msg{}
+	require.NoError(t, json.Unmarshal(<-posted, msg))
+	require.True(t, msg.ReplaceOriginal)
+	require.Equal(t, "Reps for search: shoes", msg.Text)
+}
+
+func TestCopySiteID(t *testing.T) {
+	posted := make(chan []byte, 1)
+	server := responseURLRecorder(posted)
+	defer server.Close()
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": server.URL,
+		"actions":      []map[string]string{{"block_id": "abc", "action_id": common.CopySiteIDActionID, "value": "abc123"}},
+	})
+	w := httptest.NewRecorder()
+	newRouter(&fakeRunner{}, &common.Goroutines{}).ServeHTTP(w, interactionRequest(t, string(payload)))
+
+	msg := &slack.Msg{}
+	require.NoError(t, json.Unmarshal(<-posted, msg))
+	require.False(t, msg.ReplaceOriginal)
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.Equal(t, "`abc123`", msg.Text)
+}
+
+func TestBadPayload(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRouter(&fakeRunner{}, &common.Goroutines{}).ServeHTTP(w, interactionRequest(t, "not json"))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
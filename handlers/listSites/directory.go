@@ -0,0 +1,148 @@
+package listSites
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/searchspring/nebo/dals/metabase"
+)
+
+// Site is a website in the directory, only Website and SiteId are sent unless other
+// fields are asked for
+type Site struct {
+	Website     string
+	SiteId      string
+	Active      *bool  `json:",omitempty"`
+	Platform    string `json:",omitempty"`
+	CSM         string `json:",omitempty"`
+	MRRTier     string `json:",omitempty"`
+	Integration string `json:",omitempty"`
+}
+
+// siteFields are the values fields= accepts, matched case insensitively
+var siteFields = []string{"platform", "csm", "mrrTier", "integration", "active"}
+
+// mrrTiers buckets MRR so the directory doesn't hand out exact revenue, highest first
+var mrrTiers = []struct {
+	min  float64
+	name string
+}{
+	{10000, "10k+"},
+	{5000, "5k-10k"},
+	{1000, "1k-5k"},
+	{0, "under 1k"},
+}
+
+func mrrTier(mrr float64) string {
+	if mrr <= 0 {
+		return "none"
+	}
+	for _, tier := range mrrTiers {
+		if mrr >= tier.min {
+			return tier.name
+		}
+	}
+	return "none"
+}
+
+// view is the part of the directory a request asked for
+type view struct {
+	fields   map[string]bool
+	platform string
+	csm      string
+	inactive bool
+}
+
+// parseView reads fields, platform, csm and inactive from the query string
+func parseView(query url.Values) (*view, error) {
+	v := &view{
+		fields:   map[string]bool{},
+		platform: strings.TrimSpace(query.Get("platform")),
+		csm:      strings.TrimSpace(query.Get("csm")),
+	}
+	if inactive := query.Get("inactive"); inactive != "" {
+		include, err := strconv.ParseBool(inactive)
+		if err != nil {
+			return nil, fmt.Errorf("inactive must be true or false")
+		}
+		v.inactive = include
+	}
+	for _, name := range strings.Split(query.Get("fields"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := siteField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q, fields can be %s", name, strings.Join(siteFields, ", "))
+		}
+		v.fields[field] = true
+	}
+	return v, nil
+}
+
+func siteField(name string) (string, bool) {
+	for _, field := range siteFields {
+		if strings.EqualFold(field, name) {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+// sites returns the sites in the view with the fields it asked for
+func (v *view) sites(all []metabase.DomainAndID) []Site {
+	sites := []Site{}
+	for _, site := range all {
+		if !v.matches(site) {
+			continue
+		}
+		sites = append(sites, v.site(site))
+	}
+	return sites
+}
+
+// matches checks the site is active, unless inactive sites were asked for, is on the
+// platform and has a CSM whose name contains csm
+func (v *view) matches(site metabase.DomainAndID) bool {
+	if !site.Active && !v.inactive {
+		return false
+	}
+	if v.platform != "" && !strings.EqualFold(site.Platform, v.platform) {
+		return false
+	}
+	if v.csm != "" && !strings.Contains(strings.ToLower(site.CSM), strings.ToLower(v.csm)) {
+		return false
+	}
+	return true
+}
+
+func (v *view) site(site metabase.DomainAndID) Site {
+	result := Site{Website: site.Website, SiteId: site.SiteId}
+	if v.fields["active"] {
+		active := site.Active
+		result.Active = &active
+	}
+	if v.fields["platform"] {
+		result.Platform = orUnknown(site.Platform)
+	}
+	if v.fields["csm"] {
+		result.CSM = orUnknown(site.CSM)
+	}
+	if v.fields["mrrTier"] {
+		result.MRRTier = mrrTier(site.MRR)
+	}
+	if v.fields["integration"] {
+		result.Integration = orUnknown(site.Integration)
+	}
+	return result
+}
+
+func orUnknown(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "unknown"
+	}
+	return value
+}
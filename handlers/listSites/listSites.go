@@ -1,17 +1,23 @@
 package listSites
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kelseyhightower/envconfig"
 
+	"github.com/searchspring/nebo/cache"
 	"github.com/searchspring/nebo/common"
 	"github.com/searchspring/nebo/dals/google"
 	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/services/commands"
 )
 
 var router *mux.Router
@@ -47,44 +53,79 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 func CreateRouter() (*mux.Router, error) {
 	router := mux.NewRouter()
-	googleDAO := google.NewDAO(common.NewClient(&http.Client{}))
-	metabaseDAO := metabase.NewDAO("https://metabase.kube.searchspring.io/", env.MetabaseUser, env.MetabasePassword, "")
-	router.HandleFunc("/listSites", wrapWithAuthorizedCheck(googleDAO.CheckUserLoggedIn, GetSitesList, metabaseDAO)).Methods(http.MethodGet, http.MethodOptions)
+	AddRoutes(router, env)
 	router.Use(mux.CORSMethodMiddleware(router))
 	return router, nil
 }
 
-func wrapWithAuthorizedCheck(checkUserLoggedIn func(authorizationToken string) (string, error), apiRequest func(w http.ResponseWriter, r *http.Request, metabaseDAO metabase.DAO), metabaseDAO metabase.DAO) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == http.MethodOptions {
-			return
-		}
+// AddRoutes registers /listSites on router, the metabase DAO is the one shared by the process
+func AddRoutes(router *mux.Router, env common.EnvVars) {
+	googleDAO := google.NewDAO(&http.Client{}, cache.Shared(env.CacheURL), google.NewPolicy(env))
+	versions := &versionStore{cache: cache.Shared(env.CacheURL), now: time.Now}
+	router.HandleFunc("/listSites", func(w http.ResponseWriter, r *http.Request) {
+		common.WithAuthorizedCheck(googleDAO.CheckUserLoggedIn, func(w http.ResponseWriter, r *http.Request) {
+			GetSitesList(w, r, commands.Shared(env).MetabaseDAO, versions)
+		}, "ETag", "Last-Modified", "X-Sites-Version")(w, r)
+	}).Methods(http.MethodGet, http.MethodOptions)
+}
 
-		authorization := r.Header.Get("Authorization")
-		if email, err := checkUserLoggedIn(authorization); err != nil {
-			http.Error(w, err.Error(), http.StatusForbidden)
+// GetSitesList returns the active sites, or with ?since= only the changes since that
+// version. The version is in X-Sites-Version and Last-Modified, either can be used for since.
+// fields, platform, csm and inactive choose the fields and sites returned.
+// The version is the whole directory's and covers every view, a view can only change
+// when the directory does so If-Modified-Since never misses a change, it just can't tell
+// a change outside the view from one in it. The ETag hashes the view that was sent.
+func GetSitesList(w http.ResponseWriter, r *http.Request, metabaseAPI metabase.DAO, versions *versionStore) {
+	if metabaseAPI == nil {
+		common.SendInternalServerError(w, errors.New("missing required Metabase credentials"))
+		return
+	}
+	view, err := parseView(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var since int64
+	sinceParam := r.URL.Query().Get("since")
+	if sinceParam != "" {
+		if since, err = parseSince(sinceParam); err != nil {
+			http.Error(w, "since must be a unix timestamp or an HTTP date", http.StatusBadRequest)
 			return
-		} else {
-			if !strings.HasSuffix(email, "@searchspring.com") {
-				http.Error(w, "must have searchspring.com email address to use this systsem", http.StatusForbidden)
-				return
-			}
+		}
+	}
+	sites, err := metabaseAPI.QuerySites()
+	if err != nil {
+		common.SendInternalServerError(w, err)
+		return
+	}
 
-			apiRequest(w, r, metabaseDAO)
+	latest := versions.current(sites)
+	var body interface{} = view.sites(sites)
+	etag := etagFor(body)
+	if sinceParam != "" {
+		changes := versions.changes(since, latest, view, sites)
+		etag = strings.TrimSuffix(etag, `"`) + "-" + strconv.FormatInt(since, 10)
+		if changes.Full {
+			etag += "-full"
 		}
+		etag += `"`
+		body = changes
 	}
-}
 
-func GetSitesList(w http.ResponseWriter, r *http.Request, metabaseAPI metabase.DAO) {
-	data, err := metabaseAPI.QueryAll()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", latest.Modified.Format(http.TimeFormat))
+	w.Header().Set("X-Sites-Version", strconv.FormatInt(latest.Modified.Unix(), 10))
+	w.Header().Set("Cache-Control", "no-cache")
+	if notModified(r, etag, latest.Modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	data, err := json.Marshal(body)
 	if err != nil {
 		common.SendInternalServerError(w, err)
 		return
 	}
-
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 }
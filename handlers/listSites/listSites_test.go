@@ -1,11 +1,16 @@
 package listSites
 
 import (
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/searchspring/nebo/cache"
 	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/metabase"
 	mocks "github.com/searchspring/nebo/mocks"
 	"github.com/stretchr/testify/require"
 )
@@ -25,6 +30,189 @@ func TestGetSitesList(t *testing.T) {
 	defer os.Setenv("DEV_MODE", "")
 	w := httptest.NewRecorder()
 	metabaseDAO := &mocks.MetabaseDAO{}
-	GetSitesList(w, httptest.NewRequest("GET", "localhost:3000/listSites", nil), metabaseDAO)
-	require.Equal(t, 201, w.Result().StatusCode)
+	now := time.Unix(1600000000, 0)
+	GetSitesList(w, httptest.NewRequest("GET", "localhost:3000/listSites", nil), metabaseDAO, newTestVersions(&now))
+	require.Equal(t, 200, w.Result().StatusCode)
+	require.Equal(t, "[]", w.Body.String())
+}
+
+func newTestVersions(now *time.Time) *versionStore {
+	return &versionStore{cache: cache.New(cache.NewMemory(100), nil), now: func() time.Time { return *now }}
+}
+
+func getSites(versions *versionStore, metabaseDAO *mocks.MetabaseDAO, url string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", url, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	GetSitesList(w, r, metabaseDAO, versions)
+	return w
+}
+
+func TestGetSitesListETag(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	versions := newTestVersions(&now)
+	metabaseDAO := &mocks.MetabaseDAO{Sites: []metabase.DomainAndID{{Website: "one.com", SiteId: "abc123", Active: true}}}
+
+	w := getSites(versions, metabaseDAO, "/listSites", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, "Sun, 13 Sep 2020 12:26:40 GMT", w.Header().Get("Last-Modified"))
+	require.Equal(t, "1600000000", w.Header().Get("X-Sites-Version"))
+	require.JSONEq(t, `[{"Website":"one.com","SiteId":"abc123"}]`, w.Body.String())
+
+	now = now.Add(time.Hour)
+	w = getSites(versions, metabaseDAO, "/listSites", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Body.String())
+	require.Equal(t, "1600000000", w.Header().Get("X-Sites-Version"))
+
+	w = getSites(versions, metabaseDAO, "/listSites", map[string]string{"If-Modified-Since": "Sun, 13 Sep 2020 12:26:40 GMT"})
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	metabaseDAO.Sites = append(metabaseDAO.Sites, metabase.DomainAndID{Website: "two.com", SiteId: "def456", Active: true})
+	w = getSites(versions, metabaseDAO, "/listSites", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, etag, w.Header().Get("ETag"))
+	require.Equal(t, "1600003600", w.Header().Get("X-Sites-Version"))
+}
+
+func TestGetSitesListValidatorsCoverEveryView(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	versions := newTestVersions(&now)
+	metabaseDAO := &mocks.MetabaseDAO{Sites: []metabase.DomainAndID{
+		{Website: "one.com", SiteId: "abc123", Active: true, Platform: "Shopify"},
+		{Website: "two.com", SiteId: "def456", Active: true, Platform: "Magento"},
+	}}
+	w := getSites(versions, metabaseDAO, "/listSites?platform=shopify", nil)
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+
+	// the ETag is the view's, another view's doesn't match
+	w = getSites(versions, metabaseDAO, "/listSites?platform=magento", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// a change outside the view moves the version of every view, the dates no longer
+	// match but the view's ETag still does
+	now = now.Add(time.Hour)
+	metabaseDAO.Sites[1].Website = "www.two.com"
+	w = getSites(versions, metabaseDAO, "/listSites?platform=shopify", map[string]string{"If-Modified-Since": modified})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, etag, w.Header().Get("ETag"))
+	w = getSites(versions, metabaseDAO, "/listSites?platform=shopify", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, w.Code)
+	w = getSites(versions, metabaseDAO, "/listSites?platform=magento", map[string]string{"If-Modified-Since": modified})
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGetSitesListSince(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	versions := newTestVersions(&now)
+	metabaseDAO := &mocks.MetabaseDAO{Sites: []metabase.DomainAndID{
+		{Website: "one.com", SiteId: "abc123", Active: true},
+		{Website: "two.com", SiteId: "def456", Active: true},
+		{Website: "three.com", SiteId: "ghi789", Active: true},
+	}}
+	w := getSites(versions, metabaseDAO, "/listSites", nil)
+	since := w.Header().Get("X-Sites-Version")
+
+	now = now.Add(time.Hour)
+	metabaseDAO.Sites = []metabase.DomainAndID{
+		{Website: "one.com", SiteId: "abc123", Active: true},
+		{Website: "www.two.com", SiteId: "def456", Active: true},
+		{Website: "three.com", SiteId: "ghi789"},
+		{Website: "four.com", SiteId: "jkl012", Active: true},
+	}
+	w = getSites(versions, metabaseDAO, "/listSites?since="+since, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	changes := Changes{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	require.False(t, changes.Full)
+	require.Equal(t, int64(1600003600), changes.Version)
+	require.Equal(t, int64(1600000000), changes.Since)
+	require.Equal(t, []Site{{Website: "four.com", SiteId: "jkl012"}}, changes.Added)
+	require.Equal(t, []Site{{Website: "www.two.com", SiteId: "def456"}}, changes.Changed)
+	require.Equal(t, []Site{{Website: "three.com", SiteId: "ghi789"}}, changes.Removed)
+
+	w = getSites(versions, metabaseDAO, "/listSites?since="+since, map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	w = getSites(versions, metabaseDAO, "/listSites?since=Sun,%2013%20Sep%202020%2013:26:40%20GMT", nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	require.False(t, changes.Full)
+	require.Empty(t, changes.Added)
+	require.Empty(t, changes.Changed)
+	require.Empty(t, changes.Removed)
+}
+
+func TestGetSitesListUnknownSince(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	versions := newTestVersions(&now)
+	metabaseDAO := &mocks.MetabaseDAO{Sites: []metabase.DomainAndID{{Website: "one.com", SiteId: "abc123", Active: true}}}
+
+	w := getSites(versions, metabaseDAO, "/listSites?since=1500000000", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	changes := Changes{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	require.True(t, changes.Full)
+	require.Equal(t, []Site{{Website: "one.com", SiteId: "abc123"}}, changes.Sites)
+
+	w = getSites(versions, metabaseDAO, "/listSites?since=yesterday", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetSitesListIgnoresV1Snapshots(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	versions := newTestVersions(&now)
+	// v1 snapshots were written before sites had an active flag
+	versions.cache.SetJSON(cache.Key("listSites", "version", "1500000000"), []metabase.DomainAndID{{Website: "one.com", SiteId: "abc123"}}, versionTTL)
+	metabaseDAO := &mocks.MetabaseDAO{Sites: []metabase.DomainAndID{{Website: "one.com", SiteId: "abc123", Active: true}}}
+
+	w := getSites(versions, metabaseDAO, "/listSites?since=1500000000", nil)
+	changes := Changes{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	require.True(t, changes.Full)
+	require.Empty(t, changes.Added)
+	require.Equal(t, []Site{{Website: "one.com", SiteId: "abc123"}}, changes.Sites)
+}
+
+func TestGetSitesListFields(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	versions := newTestVersions(&now)
+	metabaseDAO := &mocks.MetabaseDAO{Sites: []metabase.DomainAndID{
+		{Website: "one.com", SiteId: "abc123", Active: true, Platform: "Shopify", CSM: "Jane Doe", MRR: 6250, Integration: "v3"},
+		{Website: "two.com", SiteId: "def456", Active: true, Platform: "Magento", MRR: 400},
+		{Website: "three.com", SiteId: "ghi789", Platform: "Shopify", CSM: "Jane Doe"},
+	}}
+
+	w := getSites(versions, metabaseDAO, "/listSites?fields=platform,CSM,mrrTier,integration,active", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `[
+		{"Website":"one.com","SiteId":"abc123","Active":true,"Platform":"Shopify","CSM":"Jane Doe","MRRTier":"5k-10k","Integration":"v3"},
+		{"Website":"two.com","SiteId":"def456","Active":true,"Platform":"Magento","CSM":"unknown","MRRTier":"under 1k","Integration":"unknown"}
+	]`, w.Body.String())
+
+	w = getSites(versions, metabaseDAO, "/listSites?fields=active&inactive=true&platform=shopify", nil)
+	require.JSONEq(t, `[
+		{"Website":"one.com","SiteId":"abc123","Active":true},
+		{"Website":"three.com","SiteId":"ghi789","Active":false}
+	]`, w.Body.String())
+
+	w = getSites(versions, metabaseDAO, "/listSites?csm=jane", nil)
+	require.JSONEq(t, `[{"Website":"one.com","SiteId":"abc123"}]`, w.Body.String())
+
+	w = getSites(versions, metabaseDAO, "/listSites?fields=revenue", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = getSites(versions, metabaseDAO, "/listSites?inactive=maybe", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestMRRTier(t *testing.T) {
+	require.Equal(t, "none", mrrTier(-1))
+	require.Equal(t, "none", mrrTier(0))
+	require.Equal(t, "under 1k", mrrTier(999.99))
+	require.Equal(t, "1k-5k", mrrTier(1000))
+	require.Equal(t, "5k-10k", mrrTier(5000))
+	require.Equal(t, "10k+", mrrTier(25000))
 }
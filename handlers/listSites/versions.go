@@ -0,0 +1,147 @@
+package listSites
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/searchspring/nebo/cache"
+	"github.com/searchspring/nebo/dals/metabase"
+)
+
+// versionTTL is how long a version of the site list is kept to work out what changed since it
+const versionTTL = 30 * 24 * time.Hour
+
+// version identifies the site list as it was at Modified, ETag is a hash of its content
+type version struct {
+	ETag     string    `json:"etag"`
+	Modified time.Time `json:"modified"`
+}
+
+// Changes lists the sites added to, changed in or removed from a view since a version,
+// sites are matched by site id. Deactivated sites are removed unless the view includes
+// inactive sites. When the version is no longer known Full is set and Sites is the
+// whole view instead.
+type Changes struct {
+	Version int64  `json:"version"`
+	Since   int64  `json:"since"`
+	Full    bool   `json:"full"`
+	Sites   []Site `json:"sites,omitempty"`
+	Added   []Site `json:"added"`
+	Changed []Site `json:"changed"`
+	Removed []Site `json:"removed"`
+}
+
+// versionStore records each version of the site list in the cache. With only the memory
+// cache each instance has its own versions and a client that syncs against another
+// instance is sent the full list.
+type versionStore struct {
+	cache *cache.Cache
+	now   func() time.Time
+}
+
+// versionNamespace is bumped when the snapshots change shape, v1 snapshots have no
+// active flag so every site in them reads as inactive. Clients syncing from an older
+// version are sent the full list.
+const versionNamespace = "listSites:v2"
+
+var latestKey = cache.Key(versionNamespace, "latest")
+
+func snapshotKey(at int64) string {
+	return cache.Key(versionNamespace, "version", strconv.FormatInt(at, 10))
+}
+
+// current returns the version of the whole directory, recording a new version when
+// sites differ from the latest
+func (v *versionStore) current(sites []metabase.DomainAndID) version {
+	etag := etagFor(sites)
+	latest := version{}
+	if v.cache.GetJSON(latestKey, &latest) && latest.ETag == etag {
+		return latest
+	}
+	latest = version{ETag: etag, Modified: v.now().UTC().Truncate(time.Second)}
+	v.cache.SetJSON(snapshotKey(latest.Modified.Unix()), sites, versionTTL)
+	v.cache.SetJSON(latestKey, latest, versionTTL)
+	return latest
+}
+
+// changes compares the view of sites, the latest version, with the view of the version at since
+func (v *versionStore) changes(since int64, latest version, view *view, sites []metabase.DomainAndID) *Changes {
+	changes := &Changes{
+		Version: latest.Modified.Unix(),
+		Since:   since,
+		Added:   []Site{},
+		Changed: []Site{},
+		Removed: []Site{},
+	}
+	current := view.sites(sites)
+	all := []metabase.DomainAndID{}
+	if !v.cache.GetJSON(snapshotKey(since), &all) {
+		changes.Full = true
+		changes.Sites = current
+		return changes
+	}
+
+	old := view.sites(all)
+	previous := map[string]Site{}
+	for _, site := range old {
+		previous[site.SiteId] = site
+	}
+	for _, site := range current {
+		before, ok := previous[site.SiteId]
+		if !ok {
+			changes.Added = append(changes.Added, site)
+		} else if !reflect.DeepEqual(before, site) {
+			changes.Changed = append(changes.Changed, site)
+		}
+		delete(previous, site.SiteId)
+	}
+	for _, site := range old {
+		if _, ok := previous[site.SiteId]; ok {
+			changes.Removed = append(changes.Removed, site)
+		}
+	}
+	return changes
+}
+
+func etagFor(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// parseSince reads a version, either the unix time from X-Sites-Version or an HTTP date
+// like Last-Modified
+func parseSince(since string) (int64, error) {
+	if at, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return at, nil
+	}
+	at, err := http.ParseTime(since)
+	if err != nil {
+		return 0, err
+	}
+	return at.Unix(), nil
+}
+
+// notModified checks the request's If-None-Match, or If-Modified-Since without one,
+// against the response
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !modified.After(since)
+	}
+	return false
+}
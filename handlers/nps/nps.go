@@ -1,6 +1,7 @@
 package nps
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"github.com/nlopes/slack"
 	"github.com/searchspring/nebo/common"
 	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/services/commands"
 )
 
 type NpsMessage struct {
@@ -61,23 +63,35 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 func CreateRouter() (*mux.Router, error) {
 	router := mux.NewRouter()
-	metabaseDAO := metabase.NewDAO("https://metabase.kube.searchspring.io/", env.MetabaseUser, env.MetabasePassword, "")
-	router.HandleFunc("/nps", wrapSendNPSMessage(SendNPSMessage, &common.SlackDAOImpl{}, metabaseDAO)).Methods(http.MethodGet, http.MethodOptions)
+	AddRoutes(router, env)
 	router.Use(mux.CORSMethodMiddleware(router))
 	return router, nil
 }
 
-func wrapSendNPSMessage(apiRequest func(w http.ResponseWriter, r *http.Request, slackApi common.SlackDAO, metabaseDAO metabase.DAO), slackApi common.SlackDAO, metabaseDAO metabase.DAO) func(w http.ResponseWriter, r *http.Request) {
+// AddRoutes registers /nps on router, the metabase DAO is the one shared by the process
+func AddRoutes(router *mux.Router, env common.EnvVars) {
+	slackDAO := &common.SlackDAOImpl{}
+	router.HandleFunc("/nps", func(w http.ResponseWriter, r *http.Request) {
+		wrapSendNPSMessage(SendNPSMessage, slackDAO, commands.Shared(env).MetabaseDAO, env.SlackOauthToken)(w, r)
+	}).Methods(http.MethodGet, http.MethodOptions)
+}
+
+func wrapSendNPSMessage(apiRequest func(w http.ResponseWriter, r *http.Request, slackApi common.SlackDAO, metabaseDAO metabase.DAO, slackToken string), slackApi common.SlackDAO, metabaseDAO metabase.DAO, slackToken string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		if r.Method == http.MethodOptions {
 			return
 		}
-		apiRequest(w, r, slackApi, metabaseDAO)
+		apiRequest(w, r, slackApi, metabaseDAO, slackToken)
 	}
 }
 
-func SendNPSMessage(w http.ResponseWriter, r *http.Request, slackApi common.SlackDAO, metabaseDAO metabase.DAO) {
+func SendNPSMessage(w http.ResponseWriter, r *http.Request, slackApi common.SlackDAO, metabaseDAO metabase.DAO, slackToken string) {
+
+	if metabaseDAO == nil {
+		common.SendInternalServerError(w, errors.New("missing required Metabase credentials"))
+		return
+	}
 
 	var nps NpsMessage
 
@@ -100,7 +114,7 @@ func SendNPSMessage(w http.ResponseWriter, r *http.Request, slackApi common.Slac
 		return
 	}
 
-	err = slackApi.SendSlackMessage(env.SlackOauthToken, attachments, os.Getenv("CHANNEL_ID"))
+	err = slackApi.SendSlackMessage(slackToken, attachments, os.Getenv("CHANNEL_ID"))
 	if err != nil {
 		common.SendInternalServerError(w, err)
 		return
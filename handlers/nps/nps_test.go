@@ -23,7 +23,7 @@ func TestFindBlankEnvVars(t *testing.T) {
 
 func TestHandlerMissingEnvVars(t *testing.T) {
 	w := httptest.NewRecorder()
-	SendNPSMessage(w, httptest.NewRequest("GET", "localhost:3000/nps?name=Matt", nil), &mocks.SlackDAO{}, &mocks.MetabaseDAO{})
+	SendNPSMessage(w, httptest.NewRequest("GET", "localhost:3000/nps?name=Matt", nil), &mocks.SlackDAO{}, &mocks.MetabaseDAO{}, "")
 	require.Equal(t, 500, w.Result().StatusCode)
 }
 
@@ -32,8 +32,8 @@ func TestHandlerSendSlackMessage(t *testing.T) {
 	defer os.Setenv("DEV_MODE", "")
 	w := httptest.NewRecorder()
 	slack := &mocks.SlackDAO{}
-	SendNPSMessage(w, httptest.NewRequest("GET", "localhost:3000/nps?name=Matt&rating=10&email=matt@smith.test&website=mattsmith.test", nil), slack, &mocks.MetabaseDAO{})
-	require.Equal(t, []string{"", ""}, slack.GetValues())
+	SendNPSMessage(w, httptest.NewRequest("GET", "localhost:3000/nps?name=Matt&rating=10&email=matt@smith.test&website=mattsmith.test", nil), slack, &mocks.MetabaseDAO{}, "xoxb-test")
+	require.Equal(t, []string{"xoxb-test", ""}, slack.GetValues())
 }
 
 func TestMetabaseQuery(t *testing.T) {
@@ -52,7 +52,7 @@ func TestMetabaseSearchKey(t *testing.T) {
 	defer os.Setenv("DEV_MODE", "")
 	w := httptest.NewRecorder()
 	mbdao := &mocks.MetabaseDAO{}
-	SendNPSMessage(w, httptest.NewRequest("GET", "localhost:3000/nps?name=Matt&rating=10&email=matt@smith.test&website=mattsmith.test%20(2003)", nil), &mocks.SlackDAO{}, mbdao)
+	SendNPSMessage(w, httptest.NewRequest("GET", "localhost:3000/nps?name=Matt&rating=10&email=matt@smith.test&website=mattsmith.test%20(2003)", nil), &mocks.SlackDAO{}, mbdao, "")
 	require.Equal(t, "mattsmith", mbdao.GetSearchKey())
 }
 
@@ -2,9 +2,11 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"math/rand"
 	"net/http"
@@ -16,17 +18,13 @@ import (
 	"github.com/nlopes/slack"
 
 	"github.com/searchspring/nebo/services/aggregate"
+	"github.com/searchspring/nebo/services/commands"
 
 	"github.com/searchspring/nebo/common"
-	"github.com/searchspring/nebo/dals/metabase"
-	"github.com/searchspring/nebo/dals/nextopia"
-	"github.com/searchspring/nebo/dals/salesforce"
-
+	"github.com/searchspring/nebo/search"
 )
 
-var salesForceDAO salesforce.DAO = nil
-var nextopiaDAO nextopia.DAO = nil
-var metabaseDAO metabase.DAO = nil
+const auditReportURL = "https://salesforce-bot.vercel.app/audit"
 
 // Handler - check routing and call correct methods
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -47,49 +45,103 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		log.Print(err.Error())
 	}
 
+	// the function is frozen once it answers, slow commands are finished by a second invocation
+	NewHandler(env, common.NewSelfInvoke()).ServeHTTP(w, r)
+}
+
+// NewHandler returns the slash command handler for env, requests are verified as coming
+// from Slack. Commands that take longer than Slack waits are finished by background.
+func NewHandler(env common.EnvVars, background common.Background) http.Handler {
+	return background.Wrap(common.NewSlackVerifier(env).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleCommand(w, r, env, background)
+	})))
+}
+
+func handleCommand(w http.ResponseWriter, r *http.Request, env common.EnvVars, background common.Background) {
 	s, err := slack.SlashCommandParse(r)
 	if err != nil {
 		common.SendInternalServerError(w, err)
 		return
 	}
+	// slack escapes &, < and > in the command text
+	s.Text = html.UnescapeString(s.Text)
 
-	if !s.ValidateToken(env.SlackVerificationToken) {
-		err := errors.New("slack verification failed")
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-
-	nextopiaDAO = nextopia.NewDAO(env.NxUser, env.NxPassword)
-
-	salesForceDAO = salesforce.NewDAO(env.SfURL, env.SfUser, env.SfPassword, env.SfToken)
-	metabaseDAO = metabase.NewDAO("https://metabase.kube.searchspring.io/", env.MetabaseUser, env.MetabasePassword, "")
-
-	aggregation := aggregate.AggregateServiceImpl{
-		Deps: &aggregate.Deps{
-			MetabaseDAO: metabaseDAO,
-			SalesforceDAO: salesForceDAO,
-		},
-	}
-
+	runner := commands.Shared(env)
 
 	w.Header().Set("Content-type", "application/json")
 	switch s.Command {
 	case "/rep", "/alpha-nebo", "/nebo":
-		if strings.TrimSpace(s.Text) == "help" || strings.TrimSpace(s.Text) == "" {
+		// the first word picks the sub command, anything else is a search
+		text := strings.TrimSpace(s.Text)
+		subcommand := ""
+		if fields := strings.Fields(text); len(fields) > 0 {
+			subcommand = fields[0]
+		}
+		args := strings.TrimSpace(strings.TrimPrefix(text, subcommand))
+		switch subcommand {
+		case "", "help":
 			writeHelpNebo(w)
 			return
-		}
-		if salesForceDAO == nil {
-			common.SendInternalServerError(w, errors.New("missing required Salesforce credentials"))
+
+		case "audit":
+			auditService := &aggregate.AuditServiceImpl{
+				MetabaseDAO:   runner.MetabaseDAO,
+				SalesforceDAO: runner.SalesforceDAO,
+			}
+			respondAsync(w, r, background, s.ResponseURL, "Comparing Salesforce and Metabase, this can take a while...", func(ctx context.Context) ([]byte, error) {
+				report, err := auditService.Audit()
+				if err != nil {
+					return nil, err
+				}
+				return json.Marshal(aggregate.FormatAuditReport(report, auditReportURL))
+			})
+			return
+
+		case aggregate.MigrationCommand:
+			if args == "" {
+				writeHelpNebo(w)
+				return
+			}
+			migrationService := &aggregate.MigrationServiceImpl{
+				MetabaseDAO:   runner.MetabaseDAO,
+				NextopiaDAO:   runner.NextopiaDAO,
+				SalesforceDAO: runner.SalesforceDAO,
+			}
+			respondAsync(w, r, background, s.ResponseURL, "Looking up the migration of "+args+"...", func(ctx context.Context) ([]byte, error) {
+				migrations, err := migrationService.Lookup(ctx, args)
+				if err != nil {
+					return nil, err
+				}
+				return json.Marshal(aggregate.FormatMigrations(migrations, args))
+			})
+			return
+
+		case aggregate.ShowCommand:
+			if args == "" {
+				writeHelpNebo(w)
+				return
+			}
+			profileService := &aggregate.ProfileServiceImpl{
+				MetabaseDAO:   runner.MetabaseDAO,
+				SalesforceDAO: runner.SalesforceDAO,
+			}
+			respondAsync(w, r, background, s.ResponseURL, "Looking up "+args+"...", func(ctx context.Context) ([]byte, error) {
+				profiles, err := profileService.Show(args)
+				if err != nil {
+					return nil, err
+				}
+				return json.Marshal(aggregate.FormatProfiles(profiles, args, runner.Links))
+			})
 			return
 		}
-		responseJSON, err := aggregation.Query(s.Text)
-		if err != nil {
-			common.SendInternalServerError(w, err)
+
+		if _, err := search.Parse(s.Text); err != nil {
+			writeSearchError(w, err)
 			return
 		}
-		w.Write(responseJSON)
+		respondAsync(w, r, background, s.ResponseURL, "Searching for "+s.Text+"...", func(ctx context.Context) ([]byte, error) {
+			return runPage(ctx, runner, common.Page{Command: aggregate.Command, Text: s.Text})
+		})
 		return
 
 	case "/fire", "/firetest":
@@ -109,16 +161,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			writeHelpNeboid(w)
 			return
 		}
-		if nextopiaDAO == nil {
-			common.SendInternalServerError(w, errors.New("missing required Nextopia credentials"))
-			return
-		}
-		responseJSON, err := nextopiaDAO.Query(s.Text)
-		if err != nil {
-			common.SendInternalServerError(w, err)
-			return
-		}
-		w.Write(responseJSON)
+		writePage(w, r.Context(), runner, common.Page{Command: s.Command, Text: s.Text})
 		return
 
 	case "/neboidss":
@@ -126,22 +169,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			writeHelpNeboid(w)
 			return
 		}
-		if salesForceDAO == nil {
-			common.SendInternalServerError(w, errors.New("missing required Salesforce credentials"))
-			return
-		}
-		responseJSON, err := salesForceDAO.Query(s.Text)
-		if err != nil {
-			common.SendInternalServerError(w, err)
-			return
-		}
-		formattedRes := common.FormatAccountInfos(responseJSON, s.Text)
-		byteRes, err := json.Marshal(formattedRes)
-		if err != nil {
-			common.SendInternalServerError(w, err)
-			return
-		}
-		w.Write(byteRes)
+		writePage(w, r.Context(), runner, common.Page{Command: s.Command, Text: s.Text})
 		return
 
 	case "/meet":
@@ -184,16 +212,37 @@ func writeHelpNebo(w http.ResponseWriter) {
 		Text: "Nebo usage:\n" +
 			"`/nebo shoes` - find all customers with shoe in the name\n" +
 			"`/nebo shopify` - show {" + platformsJoined + "} clients sorted by MRR\n" +
+			"`/nebo platform:shopify mrr>1000 csm:\"Jane Doe\" state:CO active:false` - filter on fields, `:` matches part of a value, `=` and `!=` match all of it, numbers take `>`, `>=`, `<` and `<=`\n" +
+			"    fields: " + strings.Join(search.FieldNames(), ", ") + "\n" +
+			"`/nebo shoes sort:mrr` - results are ranked by site id, exact domain, then partial matches with the highest MRR first, `sort:mrr` and `sort:name` order them by MRR or website instead\n" +
+			"`/nebo shoes --sources` - also show which system each field came from\n" +
+			"`/nebo audit` - report where Salesforce and Metabase disagree on MRR, CSM and platform\n" +
+			"`/nebo show <site id|domain>` - show everything Metabase and Salesforce know about one account, including inactive, presales and sandbox sites\n" +
+			"`/nebo migration <domain|nextopia id>` - show a Nextopia customer's Searchspring website and Salesforce account, flagging customers active on both or neither\n" +
 			"`/meet <optional name>` - create a google meet link (this link has to be opened in your searchspring chrome profile or you'll end up in a different meeting :/ )\n" +
 			"`/fire` - used when our product is broken and the fire team should assemble immediately to fix it\n" +
 			"`/firedown` - used when the fire is out to produce a checklist of tasks that we forget after an intense fire\n" +
 			"`/neboidnx` - gets a Nextopia customer ID based on name or id\n" +
 			"`/neboidss` - gets a Searchspring customer ID based on name or id\n" +
-			"`/nebo help` - this message",
+			"`/nebo help` - this message\n" +
+			"`audit`, `migration`, `show` and `help` are reserved, as the first word they run the sub command instead of a search",
+	}
+	json, _ := json.Marshal(msg)
+	w.Write(json)
+}
+func writeSearchError(w http.ResponseWriter, err error) {
+	text := err.Error()
+	if parseErr, ok := err.(*search.ParseError); ok {
+		text = "Sorry, I " + parseErr.Error() + "\n```" + parseErr.Pointer() + "```"
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         text,
 	}
 	json, _ := json.Marshal(msg)
 	w.Write(json)
 }
+
 func writeHelpNeboid(w http.ResponseWriter) {
 	msg := &slack.Msg{
 		ResponseType: slack.ResponseTypeEphemeral,
@@ -236,6 +285,50 @@ func fireResponse(folderID string, responseURL string) {
 	postSlackMessage(responseURL, slack.ResponseTypeInChannel, checklist)
 }
 
+// respondAsync acknowledges the command with an ephemeral message so Slack's 3 second
+// deadline isn't missed, the lookup runs in the background once the handler has
+// returned and its result is posted to the response URL
+func respondAsync(w http.ResponseWriter, r *http.Request, background common.Background, responseURL string, ack string, lookup func(ctx context.Context) ([]byte, error)) {
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         ack,
+	}
+	json, _ := json.Marshal(msg)
+	w.Write(json)
+
+	background.Run(r, func(ctx context.Context) {
+		responseJSON, err := lookup(ctx)
+		if err != nil {
+			log.Println(err.Error())
+			err = postSlackMessage(responseURL, slack.ResponseTypeEphemeral, "Sorry, something went wrong: "+err.Error())
+		} else {
+			err = postSlackJSON(responseURL, responseJSON)
+		}
+		if err != nil {
+			log.Println(err.Error())
+		}
+	})
+}
+
+// runPage returns the first page of results for a paged command, later pages are
+// requested through the interactions handler
+func runPage(ctx context.Context, runner *commands.Runner, page common.Page) ([]byte, error) {
+	msg, err := runner.Run(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+func writePage(w http.ResponseWriter, ctx context.Context, runner *commands.Runner, page common.Page) {
+	responseJSON, err := runPage(ctx, runner, page)
+	if err != nil {
+		common.SendInternalServerError(w, err)
+		return
+	}
+	w.Write(responseJSON)
+}
+
 func postSlackMessage(responseURL string, responseType string, text string) error {
 	msg := &slack.Msg{
 		ResponseType: responseType,
@@ -245,8 +338,19 @@ func postSlackMessage(responseURL string, responseType string, text string) erro
 	if err != nil {
 		return err
 	}
-	_, err = http.Post(responseURL, "application/json", bytes.NewBuffer(json))
-	return err
+	return postSlackJSON(responseURL, json)
+}
+
+func postSlackJSON(responseURL string, body []byte) error {
+	res, err := http.Post(responseURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting to response url failed - status code: %d", res.StatusCode)
+	}
+	return nil
 }
 
 func fireChecklist(folderID string) string {
@@ -1,9 +1,17 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/nlopes/slack"
 	"github.com/searchspring/nebo/common"
 	"github.com/stretchr/testify/require"
 )
@@ -21,3 +29,68 @@ func TestFindBlankEnvVars(t *testing.T) {
 func TestTimestamp(t *testing.T) {
 	require.Equal(t, "2020-10-29-14-08", timestamp(time.Unix(1603980505, 0)))
 }
+
+func responseURLRecorder(posted chan []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted <- body
+	}))
+}
+
+func TestRespondAsync(t *testing.T) {
+	posted := make(chan []byte, 1)
+	server := responseURLRecorder(posted)
+	defer server.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	release := make(chan struct{})
+	respondAsync(w, r, &common.Goroutines{}, server.URL, "Searching for shoes...", func(ctx context.Context) ([]byte, error) {
+		<-release
+		return []byte(`{"text":"Reps for search: shoes"}`), nil
+	})
+	// the command is acknowledged while the lookup is still running
+	require.Contains(t, w.Body.String(), "Searching for shoes...")
+	require.Contains(t, w.Body.String(), slack.ResponseTypeEphemeral)
+	select {
+	case <-posted:
+		t.Fatal("posted before the lookup finished")
+	default:
+	}
+	close(release)
+	require.Equal(t, `{"text":"Reps for search: shoes"}`, string(<-posted))
+}
+
+func TestRespondAsyncOutlivesRequest(t *testing.T) {
+	posted := make(chan []byte, 1)
+	server := responseURLRecorder(posted)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)
+	background := &common.Goroutines{}
+	respondAsync(httptest.NewRecorder(), r, background, server.URL, "Searching for shoes...", func(ctx context.Context) ([]byte, error) {
+		time.Sleep(10 * time.Millisecond)
+		return []byte(`{"text":"` + fmt.Sprint(ctx.Err()) + `"}`), nil
+	})
+	// slack hangs up once it has the acknowledgement
+	cancel()
+	background.Wait()
+	require.Equal(t, `{"text":"<nil>"}`, string(<-posted))
+}
+
+func TestRespondAsyncError(t *testing.T) {
+	posted := make(chan []byte, 1)
+	server := responseURLRecorder(posted)
+	defer server.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	respondAsync(w, r, &common.Goroutines{}, server.URL, "Searching for shoes...", func(ctx context.Context) ([]byte, error) {
+		return nil, errors.New("metabase is down")
+	})
+	msg := &slack.Msg{}
+	require.NoError(t, json.Unmarshal(<-posted, msg))
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.Contains(t, msg.Text, "metabase is down")
+}
@@ -37,7 +37,6 @@ type Channel struct {
 }
 
 func Handler(w http.ResponseWriter, r *http.Request) {
-	slackDAO := &common.SlackDAOImpl{}
 	var env common.EnvVars
 	err := envconfig.Process("", &env)
 	if err != nil {
@@ -54,6 +53,19 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Println(err.Error())
 	}
+
+	NewHandler(env).ServeHTTP(w, r)
+}
+
+// NewHandler returns the events handler for env, requests are verified as coming from Slack
+func NewHandler(env common.EnvVars) http.Handler {
+	slackDAO := &common.SlackDAOImpl{}
+	return common.NewSlackVerifier(env).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleEvent(w, r, env, slackDAO)
+	}))
+}
+
+func handleEvent(w http.ResponseWriter, r *http.Request, env common.EnvVars, slackDAO common.SlackDAO) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Error reading body: %v", err)
@@ -70,12 +82,6 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if event.Token != env.SlackVerificationToken {
-		log.Printf("Error reading body: %v", err)
-		http.Error(w, "Invalid Verification Token", http.StatusBadRequest)
-		return
-	}
-
 	if event.Type == "event_callback" {
 		eventDetails := &ChannelEvent{}
 		w.Write([]byte("success"))
@@ -1,18 +1,28 @@
 package mocks
 
 import (
+	"context"
+	"time"
+
 	mb "github.com/grokify/go-metabase/metabase"
 	"github.com/searchspring/nebo/dals/metabase"
 	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
 )
 
 type MetabaseDAO struct {
 	searchKey string
+	Accounts  []*models.AccountInfo
+	Sites     []metabase.DomainAndID
+	Err       error
+	Delay     time.Duration
 }
 
-func (s *MetabaseDAO) QueryAll() ([]byte, error) {
-	response := []byte{}
-	return response, nil
+func (s *MetabaseDAO) QuerySites() ([]metabase.DomainAndID, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]metabase.DomainAndID{}, s.Sites...), nil
 }
 
 func (s *MetabaseDAO) GetSearchKey() string {
@@ -28,9 +38,44 @@ func (s *MetabaseDAO) QueryNPS(search string) (*metabase.NpsInfo, error) {
 	}, nil
 }
 
+func (s *MetabaseDAO) Search(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error) {
+	if err := wait(ctx, s.Delay); err != nil {
+		return nil, err
+	}
+	accounts, err := s.query(query.Text)
+	if err != nil {
+		return nil, err
+	}
+	return query.Filter(accounts), nil
+}
+
 func (s *MetabaseDAO) Query(search string) ([]*models.AccountInfo, error) {
+	time.Sleep(s.Delay)
+	return s.query(search)
+}
+
+func (s *MetabaseDAO) query(search string) ([]*models.AccountInfo, error) {
+	s.searchKey = search
+	if s.Err != nil {
+		return nil, s.Err
+	}
 	response := []*models.AccountInfo{}
-	return response, nil
+	return append(response, s.Accounts...), nil
+}
+
+func (s *MetabaseDAO) QueryAccounts() ([]*models.AccountInfo, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]*models.AccountInfo{}, s.Accounts...), nil
+}
+
+func (s *MetabaseDAO) Profile(key string) ([]*models.AccountInfo, error) {
+	s.searchKey = key
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]*models.AccountInfo{}, s.Accounts...), nil
 }
 
 func (s *MetabaseDAO) StructFromResult(result *mb.DatasetQueryResultsData) (*metabase.NpsInfo, error) {
@@ -41,3 +86,13 @@ func (s *MetabaseDAO) ResultToMessage(search string, result *mb.DatasetQueryResu
 	response := []*models.AccountInfo{}
 	return response, nil
 }
+
+// wait sleeps for the delay like a slow DAO, giving up when ctx is done
+func wait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,41 @@
+package mocks
+
+import (
+	"context"
+	"strings"
+
+	"github.com/searchspring/nebo/dals/nextopia"
+	"github.com/searchspring/nebo/models"
+)
+
+type NextopiaDAO struct {
+	Customers []*nextopia.NextopiaAccount
+	// AccountInfos is what Accounts returns for any query
+	AccountInfos []*models.AccountInfo
+	Err          error
+}
+
+// Search matches the query against the ids, name and URL ignoring case, in the order the accounts are listed
+func (s *NextopiaDAO) Search(ctx context.Context, query string) ([]*nextopia.NextopiaAccount, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	query = strings.ToLower(query)
+	found := []*nextopia.NextopiaAccount{}
+	for _, account := range s.Customers {
+		for _, value := range []string{account.ID1, account.ID2, account.Name, account.URL} {
+			if strings.Contains(strings.ToLower(value), query) {
+				found = append(found, account)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+func (s *NextopiaDAO) Accounts(ctx context.Context, query string) ([]*models.AccountInfo, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]*models.AccountInfo{}, s.AccountInfos...), nil
+}
@@ -1,17 +1,57 @@
 package mocks
 
 import (
+	"context"
+	"time"
+
 	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
 	"github.com/simpleforce/simpleforce"
 )
 
 type SalesforceDAO struct {
 	searchKey string
+	Accounts  []*models.AccountInfo
+	Err       error
+	Delay     time.Duration
 }
 
 func (s *SalesforceDAO) GetSearchKey() string { return s.searchKey }
+func (s *SalesforceDAO) Search(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error) {
+	if err := wait(ctx, s.Delay); err != nil {
+		return nil, err
+	}
+	accounts, err := s.query(query.Text)
+	if err != nil {
+		return nil, err
+	}
+	return query.Filter(accounts), nil
+}
+
 func (s *SalesforceDAO) Query(search string) ([]*models.AccountInfo, error) {
-	return []*models.AccountInfo{}, nil
+	time.Sleep(s.Delay)
+	return s.query(search)
+}
+
+func (s *SalesforceDAO) query(search string) ([]*models.AccountInfo, error) {
+	s.searchKey = search
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]*models.AccountInfo{}, s.Accounts...), nil
+}
+func (s *SalesforceDAO) QueryCustomers() ([]*models.AccountInfo, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]*models.AccountInfo{}, s.Accounts...), nil
+}
+func (s *SalesforceDAO) Profile(key string) ([]*models.AccountInfo, error) {
+	s.searchKey = key
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return append([]*models.AccountInfo{}, s.Accounts...), nil
 }
 func (s *SalesforceDAO) ResultToMessage(search string, result *simpleforce.QueryResult) ([]*models.AccountInfo, error) {
 	return []*models.AccountInfo{}, nil
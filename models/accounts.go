@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type AccountInfo struct {
 	Website     string
 	Manager     string
@@ -13,4 +15,27 @@ type AccountInfo struct {
 	SiteId      string
 	City        string
 	State       string
+	// SalesforceId is the Account record id and WebsiteId the websites table primary key,
+	// they are used to link to the account
+	SalesforceId string
+	WebsiteId    string
+	// Country, Owner, Created and Parent come from Salesforce, Presales and Sandbox from
+	// the websites table. Only the detailed view of one account fills all of them in.
+	Country  string
+	Owner    string
+	Created  time.Time
+	Parent   string
+	Presales bool
+	Sandbox  bool
+	// Sources maps a field name to the system its value came from, it is only set on merged accounts
+	Sources map[string]string
 }
+
+// MergeFields are the AccountInfo fields that are merged across sources, in display order
+var MergeFields = []string{"Website", "SiteId", "Active", "Type", "Manager", "MRR", "FamilyMRR", "Platform", "Integration", "Provider", "City", "State"}
+
+// LinkFields are the record ids that are merged across sources, only one source has each
+var LinkFields = []string{"SalesforceId", "WebsiteId"}
+
+// ProfileFields are the fields only the detailed view of one account shows
+var ProfileFields = []string{"Country", "Owner", "Created", "Parent", "Presales", "Sandbox"}
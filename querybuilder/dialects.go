@@ -0,0 +1,70 @@
+package querybuilder
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MySQL escapes literals for the MySQL database queried through Metabase
+var MySQL Dialect = mysql{}
+
+// SOQL escapes literals for the Salesforce Object Query Language
+var SOQL Dialect = soql{}
+
+type mysql struct{}
+
+var mysqlEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+func (mysql) Quote(value string) string {
+	return "'" + mysqlEscaper.Replace(value) + "'"
+}
+
+// QuoteLike escapes the LIKE wildcards with a backslash before quoting, the string
+// literal escaping then doubles that backslash which MySQL reads back as \% and \_
+func (mysql) QuoteLike(prefix string, value string, suffix string) string {
+	value = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(value)
+	return "'" + prefix + mysqlEscaper.Replace(value) + suffix + "'"
+}
+
+func (mysql) Bool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+type soql struct{}
+
+var soqlEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+	"\b", "\\b",
+	"\f", "\\f",
+)
+
+func (soql) Quote(value string) string {
+	return "'" + soqlEscaper.Replace(value) + "'"
+}
+
+// QuoteLike escapes the string first, SOQL treats \% and \_ inside a LIKE literal as
+// the literal characters so the wildcard escapes must not be doubled
+func (soql) QuoteLike(prefix string, value string, suffix string) string {
+	value = strings.NewReplacer("%", "\\%", "_", "\\_").Replace(soqlEscaper.Replace(value))
+	return "'" + prefix + value + suffix + "'"
+}
+
+func (soql) Bool(value bool) string {
+	return strconv.FormatBool(value)
+}
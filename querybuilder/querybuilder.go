@@ -0,0 +1,162 @@
+// Package querybuilder builds SELECT statements for Salesforce SOQL and for the MySQL
+// database behind Metabase. Neither API lets us bind parameters, so every value is
+// escaped as a literal for the dialect it is written in.
+package querybuilder
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect knows how to write literals for one query language
+type Dialect interface {
+	// Quote returns value as a quoted string literal
+	Quote(value string) string
+	// QuoteLike returns value as a quoted LIKE pattern that matches the value
+	// literally, with prefix and suffix (usually "%" or "") added unescaped
+	QuoteLike(prefix string, value string, suffix string) string
+	// Bool returns a boolean literal
+	Bool(value bool) string
+}
+
+// Condition is part of a WHERE clause
+type Condition func(d Dialect) string
+
+// Raw is a condition written by hand, it must never contain user input
+func Raw(sql string) Condition {
+	return func(d Dialect) string {
+		return sql
+	}
+}
+
+// Equals matches a field that is exactly value
+func Equals(field string, value string) Condition {
+	return func(d Dialect) string {
+		return field + " = " + d.Quote(value)
+	}
+}
+
+// NotEquals matches a field that isn't value
+func NotEquals(field string, value string) Condition {
+	return func(d Dialect) string {
+		return field + " != " + d.Quote(value)
+	}
+}
+
+var comparisons = map[string]bool{"=": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true}
+
+// Compare compares a numeric field with value, op is one of =, !=, >, >=, < or <=
+func Compare(field string, op string, value float64) Condition {
+	if !comparisons[op] {
+		op = "="
+	}
+	return func(d Dialect) string {
+		return field + " " + op + " " + strconv.FormatFloat(value, 'f', -1, 64)
+	}
+}
+
+// Is matches a boolean field
+func Is(field string, value bool) Condition {
+	return func(d Dialect) string {
+		return field + " = " + d.Bool(value)
+	}
+}
+
+// Contains matches a field that has value anywhere in it
+func Contains(field string, value string) Condition {
+	return func(d Dialect) string {
+		return field + " LIKE " + d.QuoteLike("%", value, "%")
+	}
+}
+
+// StartsWith matches a field that begins with value
+func StartsWith(field string, value string) Condition {
+	return func(d Dialect) string {
+		return field + " LIKE " + d.QuoteLike("", value, "%")
+	}
+}
+
+// And matches when every condition matches
+func And(conditions ...Condition) Condition {
+	return join(" AND ", conditions)
+}
+
+// Or matches when any condition matches
+func Or(conditions ...Condition) Condition {
+	return join(" OR ", conditions)
+}
+
+// Not matches when the condition doesn't
+func Not(condition Condition) Condition {
+	return func(d Dialect) string {
+		return "NOT (" + condition(d) + ")"
+	}
+}
+
+func join(separator string, conditions []Condition) Condition {
+	return func(d Dialect) string {
+		parts := []string{}
+		for _, condition := range conditions {
+			parts = append(parts, condition(d))
+		}
+		return "(" + strings.Join(parts, separator) + ")"
+	}
+}
+
+// Query is a SELECT statement
+type Query struct {
+	dialect Dialect
+	fields  string
+	from    string
+	where   []Condition
+	orderBy string
+	limit   int
+}
+
+// Select starts a query for the comma separated fields
+func Select(dialect Dialect, fields string) *Query {
+	return &Query{dialect: dialect, fields: fields}
+}
+
+// From sets the table or object to select from
+func (q *Query) From(from string) *Query {
+	q.from = from
+	return q
+}
+
+// Where adds a condition, multiple conditions must all match
+func (q *Query) Where(condition Condition) *Query {
+	q.where = append(q.where, condition)
+	return q
+}
+
+// OrderBy sets the ordering, e.g. "mrr DESC"
+func (q *Query) OrderBy(orderBy string) *Query {
+	q.orderBy = orderBy
+	return q
+}
+
+// Limit caps the number of rows returned
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+// String returns the statement
+func (q *Query) String() string {
+	sql := "SELECT " + q.fields + " FROM " + q.from
+	if len(q.where) > 0 {
+		parts := []string{}
+		for _, condition := range q.where {
+			parts = append(parts, condition(q.dialect))
+		}
+		sql += " WHERE " + strings.Join(parts, " AND ")
+	}
+	if q.orderBy != "" {
+		sql += " ORDER BY " + q.orderBy
+	}
+	if q.limit > 0 {
+		sql += " LIMIT " + strconv.Itoa(q.limit)
+	}
+	return sql
+}
@@ -0,0 +1,95 @@
+package querybuilder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	q := Select(MySQL, "name, mrr").From("websites").
+		Where(Raw("active")).
+		Where(Or(Contains("name", "shoes"), Equals("trackingCode", "abc123"))).
+		OrderBy("mrr DESC").String()
+	require.Equal(t, "SELECT name, mrr FROM websites WHERE active AND (name LIKE '%shoes%' OR trackingCode = 'abc123') ORDER BY mrr DESC", q)
+}
+
+func TestSelectWithoutWhere(t *testing.T) {
+	require.Equal(t, "SELECT Id FROM Account", Select(SOQL, "Id").From("Account").String())
+}
+
+func TestLimit(t *testing.T) {
+	q := Select(MySQL, "id").From("websites").Where(Compare("id", ">", 2000)).OrderBy("id").Limit(2000).String()
+	require.Equal(t, "SELECT id FROM websites WHERE id > 2000 ORDER BY id LIMIT 2000", q)
+}
+
+func TestNotAndStartsWith(t *testing.T) {
+	q := Select(SOQL, "Id").From("Account").Where(And(StartsWith("Website", "shoe"), Not(Equals("Type", "Prospect")))).String()
+	require.Equal(t, "SELECT Id FROM Account WHERE (Website LIKE 'shoe%' AND NOT (Type = 'Prospect'))", q)
+}
+
+func TestCompareAndIs(t *testing.T) {
+	q := Select(MySQL, "name").From("websites").Where(Compare("mrr", ">=", 1000.5)).Where(Is("active", false)).Where(NotEquals("csm", "Jane")).String()
+	require.Equal(t, "SELECT name FROM websites WHERE mrr >= 1000.5 AND active = FALSE AND csm != 'Jane'", q)
+	q = Select(SOQL, "Id").From("Account").Where(Compare("Chargify_MRR__c", "; DELETE", 10)).Where(Is("IsDeleted", true)).String()
+	require.Equal(t, "SELECT Id FROM Account WHERE Chargify_MRR__c = 10 AND IsDeleted = true", q)
+}
+
+func TestMySQLQuote(t *testing.T) {
+	tests := map[string]string{
+		"o'neill":           `'o\'neill'`,
+		"bath & body":       `'bath & body'`,
+		"' OR 1=1 -- ":      `'\' OR 1=1 -- '`,
+		`\'; DROP TABLE x;`: `'\\\'; DROP TABLE x;'`,
+		"line\nbreak\r\x1a": `'line\nbreak\r\Z'`,
+		"nul\x00byte":       `'nul\0byte'`,
+		`say "hi"`:          `'say \"hi\"'`,
+		"100%_real":         `'100%_real'`,
+		"ünïcödé.com":       `'ünïcödé.com'`,
+		"":                  `''`,
+	}
+	for input, expected := range tests {
+		require.Equal(t, expected, MySQL.Quote(input), input)
+	}
+}
+
+func TestMySQLQuoteLike(t *testing.T) {
+	tests := map[string]string{
+		"shoes":      `'%shoes%'`,
+		"100%":       `'%100\\%%'`,
+		"a_b":        `'%a\\_b%'`,
+		`back\slash`: `'%back\\\\slash%'`,
+		"o'neill":    `'%o\'neill%'`,
+		"%' OR '1":   `'%\\%\' OR \'1%'`,
+	}
+	for input, expected := range tests {
+		require.Equal(t, expected, MySQL.QuoteLike("%", input, "%"), input)
+	}
+}
+
+func TestSOQLQuote(t *testing.T) {
+	tests := map[string]string{
+		"o'neill":             `'o\'neill'`,
+		"bath & body":         `'bath & body'`,
+		"' OR Name != '":      `'\' OR Name != \''`,
+		`\' OR Id != null --`: `'\\\' OR Id != null --'`,
+		"tab\tnew\nline":      `'tab\tnew\nline'`,
+		`say "hi"`:            `'say \"hi\"'`,
+	}
+	for input, expected := range tests {
+		require.Equal(t, expected, SOQL.Quote(input), input)
+	}
+}
+
+func TestSOQLQuoteLike(t *testing.T) {
+	tests := map[string]string{
+		"shoes":      `'%shoes%'`,
+		"100%":       `'%100\%%'`,
+		"a_b":        `'%a\_b%'`,
+		`back\slash`: `'%back\\slash%'`,
+		"o'neill":    `'%o\'neill%'`,
+	}
+	for input, expected := range tests {
+		require.Equal(t, expected, SOQL.QuoteLike("%", input, "%"), input)
+	}
+}
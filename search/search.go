@@ -0,0 +1,361 @@
+// Package search parses /nebo queries such as
+//
+//	shoes platform:shopify mrr>1000 csm:"Jane Doe" state:CO active:false
+//
+// into free text plus typed field conditions that each DAO translates into its own
+// WHERE clause.
+package search
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/searchspring/nebo/domains"
+	"github.com/searchspring/nebo/models"
+)
+
+// Field is a field that can be filtered on
+type Field string
+
+const (
+	Platform    Field = "platform"
+	CSM         Field = "csm"
+	State       Field = "state"
+	City        Field = "city"
+	MRR         Field = "mrr"
+	FamilyMRR   Field = "familymrr"
+	Active      Field = "active"
+	Integration Field = "integration"
+	Provider    Field = "provider"
+	Site        Field = "site"
+	Website     Field = "website"
+)
+
+// FieldType decides which values and operators a field accepts
+type FieldType int
+
+const (
+	TextField FieldType = iota
+	NumberField
+	BoolField
+)
+
+var fieldTypes = map[Field]FieldType{
+	Platform:    TextField,
+	CSM:         TextField,
+	State:       TextField,
+	City:        TextField,
+	MRR:         NumberField,
+	FamilyMRR:   NumberField,
+	Active:      BoolField,
+	Integration: TextField,
+	Provider:    TextField,
+	Site:        TextField,
+	Website:     TextField,
+}
+
+// Type returns the type of the field
+func (f Field) Type() FieldType {
+	return fieldTypes[f]
+}
+
+// Operator compares a field with a value
+type Operator string
+
+const (
+	// Matches is a substring match for text and equality for numbers and booleans
+	Matches        Operator = ":"
+	Equal          Operator = "="
+	NotEqual       Operator = "!="
+	Greater        Operator = ">"
+	GreaterOrEqual Operator = ">="
+	Less           Operator = "<"
+	LessOrEqual    Operator = "<="
+)
+
+var operatorsByType = map[FieldType][]Operator{
+	TextField:   {Matches, Equal, NotEqual},
+	NumberField: {Matches, Equal, NotEqual, Greater, GreaterOrEqual, Less, LessOrEqual},
+	BoolField:   {Matches, Equal, NotEqual},
+}
+
+// Condition is a single field filter, only the value matching the field type is set
+type Condition struct {
+	Field  Field
+	Op     Operator
+	Text   string
+	Number float64
+	Bool   bool
+}
+
+// Sort is the order results are listed in, chosen with sort:mrr or sort:name
+type Sort string
+
+const (
+	// Relevance lists the best matches for the free text first, it is the default
+	Relevance Sort = "relevance"
+	// ByMRR lists the highest MRR first
+	ByMRR Sort = "mrr"
+	// ByName lists websites alphabetically
+	ByName Sort = "name"
+)
+
+var sorts = []Sort{Relevance, ByMRR, ByName}
+
+// Query is a parsed search
+type Query struct {
+	// Text is the free text part of the search, matched against website, platform and site id
+	Text       string
+	Conditions []Condition
+	// Sort is blank for the default, Relevance
+	Sort Sort
+}
+
+// Condition returns the first condition on the field
+func (q *Query) Condition(field Field) (Condition, bool) {
+	for _, c := range q.Conditions {
+		if c.Field == field {
+			return c, true
+		}
+	}
+	return Condition{}, false
+}
+
+// ParseError points at the token that couldn't be parsed
+type ParseError struct {
+	Input   string
+	Pos     int
+	Token   string
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("can't understand `%s`: %s", e.Token, e.Message)
+}
+
+// Pointer returns the input with a line of carets under the offending token
+func (e *ParseError) Pointer() string {
+	return e.Input + "\n" + strings.Repeat(" ", len([]rune(e.Input[:e.Pos]))) + strings.Repeat("^", len([]rune(e.Token)))
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+// tokenize splits on whitespace, keeping double quoted sections together
+func tokenize(input string) ([]token, error) {
+	tokens := []token{}
+	start := -1
+	quoted := false
+	for i, r := range input {
+		switch {
+		case r == '"':
+			if start == -1 {
+				start = i
+			}
+			quoted = !quoted
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if start != -1 {
+				tokens = append(tokens, token{text: input[start:i], pos: start})
+				start = -1
+			}
+		default:
+			if start == -1 {
+				start = i
+			}
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, token{text: input[start:], pos: start})
+	}
+	if quoted {
+		last := tokens[len(tokens)-1]
+		return nil, &ParseError{Input: input, Pos: last.pos, Token: last.text, Message: "missing closing quote"}
+	}
+	return tokens, nil
+}
+
+var conditionPattern = regexp.MustCompile(`^([a-zA-Z]+)(>=|<=|!=|:|=|>|<)(.*)$`)
+
+// Parse parses a search, any word that isn't a field condition is free text
+func Parse(input string) (*Query, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	query := &Query{Conditions: []Condition{}}
+	text := []string{}
+	for _, t := range tokens {
+		match := conditionPattern.FindStringSubmatch(t.text)
+		// urls like https://shoes.com are free text too
+		if match == nil || strings.HasPrefix(match[3], "//") {
+			text = append(text, strings.Trim(t.text, `"`))
+			continue
+		}
+		if strings.EqualFold(match[1], "sort") {
+			sort, message := parseSort(Operator(match[2]), strings.Trim(match[3], `"`))
+			if message != "" {
+				return nil, &ParseError{Input: input, Pos: t.pos, Token: t.text, Message: message}
+			}
+			query.Sort = sort
+			continue
+		}
+		condition, message := parseCondition(Field(strings.ToLower(match[1])), Operator(match[2]), strings.Trim(match[3], `"`))
+		if message != "" {
+			return nil, &ParseError{Input: input, Pos: t.pos, Token: t.text, Message: message}
+		}
+		query.Conditions = append(query.Conditions, condition)
+	}
+	query.Text = strings.Join(text, " ")
+	return query, nil
+}
+
+func parseSort(op Operator, value string) (Sort, string) {
+	if op != Matches && op != Equal {
+		return "", "`" + string(op) + "` can't be used with sort"
+	}
+	names := []string{}
+	for _, sort := range sorts {
+		if strings.EqualFold(value, string(sort)) {
+			return sort, ""
+		}
+		names = append(names, string(sort))
+	}
+	return "", "sort needs one of " + strings.Join(names, ", ")
+}
+
+func parseCondition(field Field, op Operator, value string) (Condition, string) {
+	fieldType, ok := fieldTypes[field]
+	if !ok {
+		return Condition{}, "unknown field `" + string(field) + "`, try one of " + strings.Join(FieldNames(), ", ")
+	}
+	if !allowed(op, operatorsByType[fieldType]) {
+		return Condition{}, "`" + string(op) + "` can't be used with " + string(field)
+	}
+	if value == "" {
+		return Condition{}, string(field) + " needs a value"
+	}
+	condition := Condition{Field: field, Op: op}
+	switch fieldType {
+	case NumberField:
+		number, err := strconv.ParseFloat(strings.TrimPrefix(strings.ReplaceAll(value, ",", ""), "$"), 64)
+		// ParseFloat reads NaN, Inf and out of range numbers, the queries can't compare them
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return Condition{}, string(field) + " needs a number"
+		}
+		condition.Number = number
+	case BoolField:
+		switch strings.ToLower(value) {
+		case "true", "yes", "1":
+			condition.Bool = true
+		case "false", "no", "0":
+			condition.Bool = false
+		default:
+			return Condition{}, string(field) + " needs true or false"
+		}
+	default:
+		condition.Text = value
+	}
+	return condition, ""
+}
+
+func allowed(op Operator, ops []Operator) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldNames returns the names of every field that can be filtered on
+func FieldNames() []string {
+	names := []string{}
+	for field := range fieldTypes {
+		names = append(names, string(field))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Matches checks an account against the query in memory, for sources that can't
+// filter on their side
+func (q *Query) Matches(account *models.AccountInfo) bool {
+	if q.Text != "" {
+		text := strings.ToLower(domains.SearchTerm(q.Text))
+		if !strings.Contains(strings.ToLower(account.Website), text) &&
+			!strings.Contains(strings.ToLower(account.Platform), text) &&
+			!strings.EqualFold(account.SiteId, q.Text) {
+			return false
+		}
+	}
+	return q.MatchesConditions(account)
+}
+
+// Filter returns the accounts that match the query
+func (q *Query) Filter(accounts []*models.AccountInfo) []*models.AccountInfo {
+	filtered := []*models.AccountInfo{}
+	for _, account := range accounts {
+		if q.Matches(account) {
+			filtered = append(filtered, account)
+		}
+	}
+	return filtered
+}
+
+// MatchesConditions checks an account against the field conditions only
+func (q *Query) MatchesConditions(account *models.AccountInfo) bool {
+	for _, c := range q.Conditions {
+		if !c.matches(account) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) matches(account *models.AccountInfo) bool {
+	switch c.Field.Type() {
+	case NumberField:
+		value := account.MRR
+		if c.Field == FamilyMRR {
+			value = account.FamilyMRR
+		}
+		switch c.Op {
+		case Greater:
+			return value > c.Number
+		case GreaterOrEqual:
+			return value >= c.Number
+		case Less:
+			return value < c.Number
+		case LessOrEqual:
+			return value <= c.Number
+		case NotEqual:
+			return value != c.Number
+		}
+		return value == c.Number
+	case BoolField:
+		return (account.Active == "Active") == (c.Bool != (c.Op == NotEqual))
+	}
+	value := map[Field]string{
+		Platform:    account.Platform,
+		CSM:         account.Manager,
+		State:       account.State,
+		City:        account.City,
+		Integration: account.Integration,
+		Provider:    account.Provider,
+		Site:        account.SiteId,
+		Website:     account.Website,
+	}[c.Field]
+	switch c.Op {
+	case Equal:
+		return strings.EqualFold(value, c.Text)
+	case NotEqual:
+		return !strings.EqualFold(value, c.Text)
+	}
+	return strings.Contains(strings.ToLower(value), strings.ToLower(c.text()))
+}
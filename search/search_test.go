@@ -0,0 +1,111 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/searchspring/nebo/models"
+	qb "github.com/searchspring/nebo/querybuilder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`shoes platform:shopify mrr>1000 csm:"Jane Doe" state=CO active:false`)
+	require.NoError(t, err)
+	require.Equal(t, "shoes", q.Text)
+	require.Equal(t, []Condition{
+		{Field: Platform, Op: Matches, Text: "shopify"},
+		{Field: MRR, Op: Greater, Number: 1000},
+		{Field: CSM, Op: Matches, Text: "Jane Doe"},
+		{Field: State, Op: Equal, Text: "CO"},
+		{Field: Active, Op: Matches, Bool: false},
+	}, q.Conditions)
+}
+
+func TestParseFreeText(t *testing.T) {
+	q, err := Parse(`bath & body o'neill https://shoes.com "quoted words"`)
+	require.NoError(t, err)
+	require.Equal(t, "bath & body o'neill https://shoes.com quoted words", q.Text)
+	require.Empty(t, q.Conditions)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"shoes mrr>lots":   "mrr>lots",
+		"plaform:shopify":  "plaform:shopify",
+		"platform>shopify": "platform>shopify",
+		"active:maybe":     "active:maybe",
+		`shoes csm:"Jane`:  `csm:"Jane`,
+		"shoes state: CO":  "state:",
+		"shoes sort:size":  "sort:size",
+		"shoes sort>mrr":   "sort>mrr",
+	}
+	for input, token := range tests {
+		_, err := Parse(input)
+		require.Error(t, err, input)
+		parseErr, ok := err.(*ParseError)
+		require.True(t, ok, input)
+		require.Equal(t, token, parseErr.Token, input)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	q, err := Parse("shoes sort:MRR platform:shopify")
+	require.NoError(t, err)
+	require.Equal(t, "shoes", q.Text)
+	require.Equal(t, ByMRR, q.Sort)
+	require.Equal(t, 1, len(q.Conditions))
+
+	q, err = Parse("sort=name")
+	require.NoError(t, err)
+	require.Equal(t, ByName, q.Sort)
+
+	q, err = Parse("shoes")
+	require.NoError(t, err)
+	require.Equal(t, Sort(""), q.Sort)
+
+	_, err = Parse("shoes sort:size")
+	require.EqualError(t, err, "can't understand `sort:size`: sort needs one of relevance, mrr, name")
+}
+
+func TestParseErrorPointer(t *testing.T) {
+	_, err := Parse("shoes mrr>lots")
+	require.Equal(t, "shoes mrr>lots\n      ^^^^^^^^", err.(*ParseError).Pointer())
+	require.Equal(t, "can't understand `mrr>lots`: mrr needs a number", err.Error())
+}
+
+func TestParseRejectsNonFiniteNumbers(t *testing.T) {
+	for _, text := range []string{"mrr>inf", "mrr:nan", "familymrr<-Inf", "mrr>=1e999", "mrr:NaN"} {
+		_, err := Parse(text)
+		require.Error(t, err, text)
+		require.Contains(t, err.Error(), "needs a number", text)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	q, err := Parse(`platform:shopify mrr>=1000 csm!="Jane" active:false`)
+	require.NoError(t, err)
+	sql := qb.Select(qb.MySQL, "name").From("websites").
+		Where(q.Conditions[0].Where("platform_smart")).
+		Where(q.Conditions[1].Where("mrr")).
+		Where(q.Conditions[2].Where("csm")).
+		Where(q.Conditions[3].Where("active")).String()
+	require.Equal(t, "SELECT name FROM websites WHERE platform_smart LIKE '%shopify%' AND mrr >= 1000 AND csm != 'Jane' AND active = FALSE", sql)
+}
+
+func TestFilter(t *testing.T) {
+	accounts := []*models.AccountInfo{
+		{Website: "shoes.com", Platform: "Shopify", MRR: 1500, Active: "Active", State: "CO"},
+		{Website: "boots.com", Platform: "Shopify Plus", MRR: 500, Active: "Active", State: "CO"},
+		{Website: "shoestore.com", Platform: "Magento", MRR: 2500, Active: "Not active", State: "NY"},
+	}
+	q, _ := Parse("platform:shopify mrr>1000")
+	require.Equal(t, 1, len(q.Filter(accounts)))
+	q, _ = Parse("shoes active:false")
+	require.Equal(t, "shoestore.com", q.Filter(accounts)[0].Website)
+	q, _ = Parse("state=co")
+	require.Equal(t, 2, len(q.Filter(accounts)))
+	q, _ = Parse("https://www.Shoes.com/store")
+	require.Equal(t, "shoes.com", q.Filter(accounts)[0].Website)
+	q, _ = Parse("website:WWW.boots.com/")
+	require.Equal(t, "boots.com", q.Filter(accounts)[0].Website)
+}
@@ -0,0 +1,36 @@
+package search
+
+import (
+	"github.com/searchspring/nebo/domains"
+	qb "github.com/searchspring/nebo/querybuilder"
+)
+
+// Where translates the condition into a WHERE condition on a DAO's column
+func (c Condition) Where(column string) qb.Condition {
+	switch c.Field.Type() {
+	case NumberField:
+		op := string(c.Op)
+		if c.Op == Matches {
+			op = "="
+		}
+		return qb.Compare(column, op, c.Number)
+	case BoolField:
+		return qb.Is(column, c.Bool != (c.Op == NotEqual))
+	}
+	switch c.Op {
+	case Equal:
+		return qb.Equals(column, c.Text)
+	case NotEqual:
+		return qb.NotEquals(column, c.Text)
+	}
+	return qb.Contains(column, c.text())
+}
+
+// text is the value to match, a website given as a URL is matched by its host so
+// website:www.Shoes.com/store finds shoes.com
+func (c Condition) text() string {
+	if c.Field == Website && c.Op == Matches {
+		return domains.SearchTerm(c.Text)
+	}
+	return c.Text
+}
@@ -1,137 +1,163 @@
 package aggregate
 
 import (
-	"encoding/json"
-	"sort"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"strings"
+	"time"
 
+	"github.com/nlopes/slack"
 	"github.com/searchspring/nebo/common"
 	"github.com/searchspring/nebo/dals/metabase"
 	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/domains"
 	"github.com/searchspring/nebo/models"
+	searchquery "github.com/searchspring/nebo/search"
 )
 
+// DefaultSourceTimeout is how long each source gets to answer before it is reported as unavailable
+const DefaultSourceTimeout = 10 * time.Second
+
 type Deps struct {
-	MetabaseDAO   metabase.DAO
-	SalesforceDAO salesforce.DAO
+	Sources *Registry
+	// FieldPriority lists, per AccountInfo field, the source names to take that
+	// field from in order of preference. Other fields come from the highest
+	// priority source that has the account.
+	FieldPriority map[string][]string
+	Timeout       time.Duration
+	// Links are where account cards link to
+	Links common.AccountLinks
 }
 
+// Command is the slash command whose pages the aggregate service renders
+const Command = "/nebo"
+
 type AggregateService interface {
-	Query(query string) ([]byte, error)
+	Query(ctx context.Context, query string, offset int) (*slack.Msg, error)
 }
 
 type AggregateServiceImpl struct {
 	Deps *Deps
 }
 
-func (d *AggregateServiceImpl) Query(search string) ([]byte, error) {
-	metabaseData, err := d.Deps.MetabaseDAO.Query(search)
+type sourceResult struct {
+	accounts []*models.AccountInfo
+	err      error
+}
+
+// Query parses the search and runs it against every source in parallel, merging what
+// comes back. A *search.ParseError is returned for a search that can't be parsed. A
+// source that errors or misses its deadline doesn't fail the search, it is listed in
+// the message as unavailable instead. An error is only returned when no source answered.
+// The message shows one page of the results starting at offset.
+func (d *AggregateServiceImpl) Query(ctx context.Context, search string, offset int) (*slack.Msg, error) {
+	page := common.Page{Command: Command, Text: search, Offset: offset}
+	search, showSources := extractFlag(search, "--sources")
+	query, err := searchquery.Parse(search)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
-	salesforceData, err := d.Deps.SalesforceDAO.Query(search)
-	if err != nil {
-		return nil, nil
+	timeout := d.Deps.Timeout
+	if timeout == 0 {
+		timeout = DefaultSourceTimeout
 	}
 
-	aggregatedData := addMetabaseAccounts(metabaseData, salesforceData)
-	aggregatedData = addSalesforceAccounts(aggregatedData, salesforceData)
+	sources := d.Deps.Sources.Sources()
+	pending := make([]chan sourceResult, len(sources))
+	for i, source := range sources {
+		pending[i] = make(chan sourceResult, 1)
+		go func(source AccountSource, result chan<- sourceResult) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			accounts, err := source.Query(ctx, query)
+			result <- sourceResult{accounts: accounts, err: err}
+		}(source, pending[i])
+	}
 
-	aggregatedData = cleanAccounts(aggregatedData)
-	if !isPlatformSearch(search) {
-		aggregatedData = sortAccounts(aggregatedData, "website")
+	results := []sourceRecords{}
+	unavailable := []string{}
+	for i, source := range sources {
+		res := <-pending[i]
+		if res.err != nil {
+			unavailable = append(unavailable, unavailableSource(source.Name(), res.err))
+			continue
+		}
+		results = append(results, sourceRecords{source: source.Name(), accounts: res.accounts})
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no account sources available: %s", strings.Join(unavailable, ", "))
 	}
-	aggregatedData = truncateToTwenty(aggregatedData)
-	aggregatedData = sortAccounts(aggregatedData, "mrr")
 
-	msg := common.FormatAccountInfos(aggregatedData, search)
-	return json.Marshal(msg)
-}
+	aggregatedData := mergeAccounts(results, d.Deps.FieldPriority)
 
-// helper functions
+	// rank every result before paging so the best matches are on the first page
+	aggregatedData = rankAccounts(cleanAccounts(aggregatedData), query)
+	start, end := page.Bounds(len(aggregatedData))
+	pageData := aggregatedData[start:end]
 
-func addMetabaseAccounts(metabaseData []*models.AccountInfo, salesforceData []*models.AccountInfo) []*models.AccountInfo {
-	var customerData []*models.AccountInfo
-	for _, v := range metabaseData {
-		e, i := exists(v.SiteId, v.Website, salesforceData)
-		if e {
-			if salesforceData[i].Type == "Customer" || salesforceData[i].Type == "Inactive Customer" {
-				customerData = append(customerData, v)
-			}
-		} else {
-			customerData = append(customerData, v)
-		}
+	msg := common.FormatAccountBlocks(pageData, search, common.CardOptions{ShowSources: showSources, Links: d.Deps.Links})
+	if len(unavailable) > 0 {
+		msg.Text += "\n:warning: Results may be incomplete, unavailable sources: " + strings.Join(unavailable, ", ")
 	}
-	return customerData
+	common.AddPaging(msg, page, len(aggregatedData))
+	return msg, nil
 }
 
-func addSalesforceAccounts(currentCustomerData []*models.AccountInfo, salesforceData []*models.AccountInfo) []*models.AccountInfo {
-	for _, v := range salesforceData {
-		e, _ := exists(v.SiteId, v.Website, currentCustomerData) 
-		if !e {
-			if v.Type == "Customer" || v.Type == "Inactive Customer" {
-				currentCustomerData = append(currentCustomerData, v)
-			}
-		}
+func unavailableSource(name string, err error) string {
+	log.Printf("%s query failed: %s", name, err.Error())
+	reason := "error"
+	var loginErr *salesforce.LoginError
+	var authErr *metabase.AuthError
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, metabase.ErrTimeout) {
+		reason = "timed out"
+	} else if errors.Is(err, errNotConfigured) {
+		reason = err.Error()
+	} else if errors.As(err, &loginErr) {
+		reason = loginErr.Error()
+	} else if errors.As(err, &authErr) {
+		reason = authErr.Error()
 	}
-	return currentCustomerData
+	return name + " (" + reason + ")"
 }
 
+// helper functions
+
+// exists finds the account with the site id or the same website, websites are compared
+// by their canonical host so https://www.Shoes.com/store is shoes.com
 func exists(id string, website string, data []*models.AccountInfo) (result bool, index int) {
 	for i, account := range data {
-		if account.Website == website || account.SiteId == id && id != "unknown" {
+		if domains.Same(account.Website, website) || account.SiteId == id && id != "unknown" && id != "" {
 			return true, i
 		}
 	}
 	return false, -1
 }
 
-// cleaning account arrays
-
-func truncateToTwenty(accounts []*models.AccountInfo) []*models.AccountInfo {
-	truncated := []*models.AccountInfo{}
-	for i, account := range accounts {
-		if i == 20 {
-			break
+// extractFlag removes a --flag from the search, reporting whether it was there
+func extractFlag(search string, flag string) (string, bool) {
+	found := false
+	words := []string{}
+	for _, word := range strings.Fields(search) {
+		if word == flag {
+			found = true
+			continue
 		}
-		truncated = append(truncated, account)
+		words = append(words, word)
 	}
-	return truncated
+	return strings.Join(words, " "), found
 }
 
-func isPlatformSearch(search string) bool {
-	for _, platform := range common.Platforms {
-		if strings.EqualFold(search, platform) {
-			return true
-		}
-	}
-	return false
-}
+// cleaning account arrays
 
+// cleanAccounts shows every website as its canonical domain, websites that have none,
+// e.g. unknown, are left as they are
 func cleanAccounts(accounts []*models.AccountInfo) []*models.AccountInfo {
 	for _, account := range accounts {
-		w := account.Website
-		if strings.HasPrefix(w, "http://") || strings.HasPrefix(w, "https://") {
-			w = w[strings.Index(w, ":")+3:]
+		if website := domains.Canonical(account.Website); website != "" {
+			account.Website = website
 		}
-		if strings.HasPrefix(w, "www.") {
-			w = w[4:]
-		}
-		if strings.HasSuffix(w, "/") {
-			w = w[0 : len(w)-1]
-		}
-		account.Website = w
 	}
 	return accounts
 }
-
-func sortAccounts(accounts []*models.AccountInfo, sortType string) []*models.AccountInfo {
-	sort.Slice(accounts, func(i, j int) bool {
-		if sortType == "website" {
-			return len(accounts[i].Website) < len(accounts[j].Website)
-		} else {
-			return accounts[i].MRR > accounts[j].MRR
-		}
-	})
-	return accounts
-}
@@ -1,9 +1,18 @@
 package aggregate
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/mocks"
 	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,33 +44,274 @@ func salesforceCustomers() []*models.AccountInfo {
 	}
 }
 
-func TestAddingMetabaseAccounts(t *testing.T) {
-	metabaseAccounts := addMetabaseAccounts(metabaseCustomers(), salesforceCustomers())
+func TestMergeDropsNonCustomers(t *testing.T) {
+	accounts := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: metabaseCustomers()},
+		{source: SalesforceSource, accounts: salesforceCustomers()[1:]},
+	}, nil)
 
-	require.Equal(t, 1, len(metabaseAccounts))
-	require.Equal(t, "abcdef", metabaseAccounts[0].SiteId)
-	require.Equal(t, "two.com", metabaseAccounts[0].Website)
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "abcdef", accounts[0].SiteId)
+	require.Equal(t, "two.com", accounts[0].Website)
 }
 
-func TestAddingSalesforceAccounts(t *testing.T) {
-	combinedAccounts := addSalesforceAccounts(metabaseCustomers(), salesforceCustomers())
+func TestMergeAddsSalesforceCustomers(t *testing.T) {
+	accounts := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: metabaseCustomers()},
+		{source: SalesforceSource, accounts: salesforceCustomers()[:1]},
+	}, nil)
 
-	require.Equal(t, 3, len(combinedAccounts))
-	require.Equal(t, "123456", combinedAccounts[0].SiteId)
-	require.Equal(t, "one.com", combinedAccounts[0].Website)
-	require.Equal(t, "abcdef", combinedAccounts[1].SiteId)
-	require.Equal(t, "two.com", combinedAccounts[1].Website)
-	require.Equal(t, "123abc", combinedAccounts[2].SiteId)
-	require.Equal(t, "three.com", combinedAccounts[2].Website)
+	require.Equal(t, 3, len(accounts))
+	require.Equal(t, "123456", accounts[0].SiteId)
+	require.Equal(t, "one.com", accounts[0].Website)
+	require.Equal(t, "abcdef", accounts[1].SiteId)
+	require.Equal(t, "two.com", accounts[1].Website)
+	require.Equal(t, "123abc", accounts[2].SiteId)
+	require.Equal(t, "three.com", accounts[2].Website)
 }
 
-func TestAddingSFAndMBAccounts(t *testing.T) {
-	combinedAccounts := addMetabaseAccounts(metabaseCustomers(), salesforceCustomers())
-	combinedAccounts = addSalesforceAccounts(combinedAccounts, salesforceCustomers())
+func TestMergeSFAndMBAccounts(t *testing.T) {
+	accounts := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: metabaseCustomers()},
+		{source: SalesforceSource, accounts: salesforceCustomers()},
+	}, nil)
 
-	require.Equal(t, 2, len(combinedAccounts))
-	require.Equal(t, "abcdef", combinedAccounts[0].SiteId)
-	require.Equal(t, "two.com", combinedAccounts[0].Website)
-	require.Equal(t, "123abc", combinedAccounts[1].SiteId)
-	require.Equal(t, "three.com", combinedAccounts[1].Website)
+	require.Equal(t, 2, len(accounts))
+	require.Equal(t, "abcdef", accounts[0].SiteId)
+	require.Equal(t, "two.com", accounts[0].Website)
+	require.Equal(t, "123abc", accounts[1].SiteId)
+	require.Equal(t, "three.com", accounts[1].Website)
+}
+
+func TestMergeMatchesCanonicalWebsites(t *testing.T) {
+	merged := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: []*models.AccountInfo{{SiteId: "abc123", Website: "shoes.com", MRR: 100}}},
+		{source: SalesforceSource, accounts: []*models.AccountInfo{{Type: "Customer", Website: "https://www.Shoes.com/store", Manager: "Jane"}}},
+	}, nil)
+	require.Equal(t, 1, len(merged))
+	require.Equal(t, "Jane", merged[0].Manager)
+	require.Equal(t, SalesforceSource, merged[0].Sources["Manager"])
+}
+
+func TestMergeThreeSources(t *testing.T) {
+	accounts := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: []*models.AccountInfo{{SiteId: "abcdef", Website: "two.com", MRR: 100, Manager: "Jane"}}},
+		{source: SalesforceSource, accounts: []*models.AccountInfo{{Type: "Customer", SiteId: "abcdef", Website: "www.two.com", MRR: 120, Manager: "Joe"}}},
+		{source: NextopiaSource, accounts: []*models.AccountInfo{{SiteId: "0f3a", Website: "two.com"}, {SiteId: "9b1c", Website: "four.com"}}},
+	}, map[string][]string{"MRR": {SalesforceSource, MetabaseSource}})
+
+	require.Equal(t, 2, len(accounts))
+	require.Equal(t, "abcdef", accounts[0].SiteId)
+	require.Equal(t, float64(120), accounts[0].MRR)
+	require.Equal(t, "Jane", accounts[0].Manager)
+	require.Equal(t, "four.com", accounts[1].Website)
+}
+
+func TestMergeKeepsRecordIds(t *testing.T) {
+	accounts := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: []*models.AccountInfo{{SiteId: "abcdef", Website: "two.com", WebsiteId: "42"}}},
+		{source: SalesforceSource, accounts: []*models.AccountInfo{{Type: "Customer", SiteId: "abcdef", Website: "two.com", SalesforceId: "0015000000abcDE"}}},
+	}, nil)
+
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "42", accounts[0].WebsiteId)
+	require.Equal(t, "0015000000abcDE", accounts[0].SalesforceId)
+	require.NotContains(t, accounts[0].Sources, "SalesforceId")
+}
+
+func TestMergeFillsUnknownFields(t *testing.T) {
+	accounts := mergeAccounts([]sourceRecords{
+		{source: MetabaseSource, accounts: []*models.AccountInfo{{SiteId: "abcdef", Website: "two.com", Manager: "unknown", MRR: 100, City: "unknown"}}},
+		{source: SalesforceSource, accounts: []*models.AccountInfo{{Type: "Customer", SiteId: "abcdef", Website: "two.com", Manager: "Jane", MRR: 120, City: "Denver"}}},
+	}, nil)
+
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "Jane", accounts[0].Manager)
+	require.Equal(t, SalesforceSource, accounts[0].Sources["Manager"])
+	require.Equal(t, "Denver", accounts[0].City)
+	require.Equal(t, float64(100), accounts[0].MRR)
+	require.Equal(t, MetabaseSource, accounts[0].Sources["MRR"])
+}
+
+func TestQueryShowsSources(t *testing.T) {
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(
+				NewMetabaseSource(&mocks.MetabaseDAO{Accounts: []*models.AccountInfo{{SiteId: "abcdef", Website: "two.com", Manager: "unknown", MRR: 100}}}),
+				NewSalesforceSource(&mocks.SalesforceDAO{Accounts: []*models.AccountInfo{{Type: "Customer", SiteId: "abcdef", Website: "two.com", Manager: "Jane"}}}),
+			),
+		},
+	}
+	msg, err := service.Query(context.Background(), "two.com --sources", 0)
+	require.NoError(t, err)
+	require.Equal(t, "Reps for search: two.com", msg.Text)
+	sources := msg.Blocks.BlockSet[2].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	require.Contains(t, sources.Text, "Metabase: Website, SiteId, Active, MRR")
+	require.Contains(t, sources.Text, "Salesforce: Type, Rep")
+}
+
+func TestCompareAccounts(t *testing.T) {
+	report := compareAccounts([]*models.AccountInfo{
+		{SiteId: "123456", Website: "one.com", MRR: 100, Manager: "Jane", Platform: "shopify"},
+		{SiteId: "abcdef", Website: "two.com", MRR: 50, Manager: "Joe", Platform: "Magento"},
+	}, []*models.AccountInfo{
+		{SiteId: "123456", Website: "www.one.com", MRR: 120, Manager: "jane", Platform: "Shopify Plus"},
+		{SiteId: "zzz999", Website: "three.com", MRR: 10, Manager: "Ann"},
+	})
+
+	require.Equal(t, 1, len(report.MRRMismatches))
+	require.Equal(t, "100.00", report.MRRMismatches[0].Metabase)
+	require.Equal(t, "120.00", report.MRRMismatches[0].Salesforce)
+	require.Equal(t, 0, len(report.CSMMismatches))
+	require.Equal(t, 1, len(report.PlatformMismatches))
+	require.Equal(t, 1, len(report.MetabaseOrphans))
+	require.Equal(t, "abcdef", report.MetabaseOrphans[0].SiteId)
+	require.Equal(t, 1, len(report.SalesforceOrphans))
+	require.Equal(t, "zzz999", report.SalesforceOrphans[0].SiteId)
+
+	msg := FormatAuditReport(report, "https://example.com/audit")
+	require.Equal(t, "MRR mismatches (1)", msg.Attachments[0].AuthorName)
+}
+
+func TestRegistryOrdersByPriority(t *testing.T) {
+	registry := NewRegistry(NewNextopiaSource(nil), NewSalesforceSource(nil), NewMetabaseSource(nil))
+	sources := registry.Sources()
+	require.Equal(t, MetabaseSource, sources[0].Name())
+	require.Equal(t, SalesforceSource, sources[1].Name())
+	require.Equal(t, NextopiaSource, sources[2].Name())
+}
+
+func TestQueryPartialResults(t *testing.T) {
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(
+				NewMetabaseSource(&mocks.MetabaseDAO{Accounts: metabaseCustomers()}),
+				NewSalesforceSource(&mocks.SalesforceDAO{Err: errors.New("INVALID_SESSION_ID")}),
+			),
+		},
+	}
+	msg, err := service.Query(context.Background(), "com", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(cardTitles(msg)))
+	require.Contains(t, msg.Text, "Salesforce (error)")
+}
+
+func TestQueryLoginFailure(t *testing.T) {
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(
+				NewMetabaseSource(&mocks.MetabaseDAO{Accounts: metabaseCustomers()}),
+				NewSalesforceSource(&mocks.SalesforceDAO{Err: &salesforce.LoginError{Reason: "INVALID_LOGIN: Invalid username, password, security token; or user locked out."}}),
+			),
+		},
+	}
+	msg, err := service.Query(context.Background(), "com", 0)
+	require.NoError(t, err)
+	require.Contains(t, msg.Text, "Salesforce (salesforce login failed: INVALID_LOGIN: Invalid username, password, security token; or user locked out.)")
+}
+
+func TestQuerySourceTimeout(t *testing.T) {
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(
+				NewMetabaseSource(&mocks.MetabaseDAO{Delay: time.Second}),
+				NewSalesforceSource(&mocks.SalesforceDAO{Accounts: salesforceCustomers()}),
+			),
+			Timeout: 10 * time.Millisecond,
+		},
+	}
+	msg, err := service.Query(context.Background(), "com", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(cardTitles(msg)))
+	require.Contains(t, msg.Text, "Metabase (timed out)")
+}
+
+func TestQueryAllSourcesUnavailable(t *testing.T) {
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(
+				NewMetabaseSource(&mocks.MetabaseDAO{Err: errors.New("boom")}),
+				NewSalesforceSource(nil),
+			),
+		},
+	}
+	_, err := service.Query(context.Background(), "com", 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Salesforce (not configured)")
+}
+
+func TestQueryPaging(t *testing.T) {
+	accounts := []*models.AccountInfo{}
+	for i := 0; i < 25; i++ {
+		accounts = append(accounts, &models.AccountInfo{SiteId: fmt.Sprintf("site%02d", i), Website: fmt.Sprintf("shop%02d.com", i), MRR: float64(i)})
+	}
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(NewMetabaseSource(&mocks.MetabaseDAO{Accounts: accounts})),
+		},
+	}
+
+	msg, err := service.Query(context.Background(), "shop", 0)
+	require.NoError(t, err)
+	require.Equal(t, common.PageSize, len(cardTitles(msg)))
+	require.LessOrEqual(t, len(msg.Blocks.BlockSet), common.MaxBlocks)
+
+	msg, err = service.Query(context.Background(), "shop", common.PageSize)
+	require.NoError(t, err)
+	require.Equal(t, 25-common.PageSize, len(cardTitles(msg)))
+	header := msg.Blocks.BlockSet[0].(*slack.SectionBlock)
+	require.Contains(t, header.Text.Text, fmt.Sprintf("Showing %d-25 of 25", common.PageSize+1))
+	paging := msg.Blocks.BlockSet[len(msg.Blocks.BlockSet)-1].(*slack.ActionBlock)
+	require.Equal(t, "paging", paging.BlockID)
+	buttons := paging.Elements.ElementSet
+	require.Equal(t, 1, len(buttons))
+	require.Equal(t, common.PreviousPageActionID, buttons[0].(*slack.ButtonBlockElement).ActionID)
+}
+
+// cardTitles returns the title of each account card, skipping the paging header
+func cardTitles(msg *slack.Msg) []string {
+	titles := []string{}
+	for _, block := range msg.Blocks.BlockSet[1:] {
+		if section, ok := block.(*slack.SectionBlock); ok {
+			titles = append(titles, section.Text.Text)
+		}
+	}
+	return titles
+}
+
+func TestCleanAccountsShowsCanonicalDomains(t *testing.T) {
+	accounts := cleanAccounts([]*models.AccountInfo{
+		{Website: "https://www.Shoes.com/store"},
+		{Website: "unknown"},
+	})
+	require.Equal(t, "shoes.com", accounts[0].Website)
+	require.Equal(t, "unknown", accounts[1].Website)
+}
+
+func TestNextopiaSourceSkipsSearchesWithoutText(t *testing.T) {
+	source := NewNextopiaSource(&mocks.NextopiaDAO{Err: errors.New("nextopia was searched")})
+	accounts, err := source.Query(context.Background(), &search.Query{Conditions: []search.Condition{{Field: search.Active, Op: search.Equal, Bool: false}}})
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+
+	_, err = source.Query(context.Background(), &search.Query{Text: "shoes"})
+	require.Error(t, err)
+}
+
+func TestNextopiaSourceHidesInactiveAccounts(t *testing.T) {
+	source := NewNextopiaSource(&mocks.NextopiaDAO{AccountInfos: []*models.AccountInfo{
+		{Website: "shoes.com", SiteId: "ee33869e", Active: "Active"},
+		{Website: "oldshoes.com", SiteId: "3502dc10", Active: "Not active"},
+	}})
+	accounts, err := source.Query(context.Background(), &search.Query{Text: "shoes"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "shoes.com", accounts[0].Website)
+
+	query, err := search.Parse("shoes active:false")
+	require.NoError(t, err)
+	accounts, err = source.Query(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts))
+	require.Equal(t, "oldshoes.com", accounts[0].Website)
 }
@@ -0,0 +1,177 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/models"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// AuditService reports where Salesforce and the Searchspring websites table disagree
+type AuditService interface {
+	Audit() (*AuditReport, error)
+}
+
+type AuditServiceImpl struct {
+	MetabaseDAO   metabase.DAO
+	SalesforceDAO salesforce.DAO
+}
+
+// Mismatch is a field that has different values in Metabase and Salesforce
+type Mismatch struct {
+	SiteId     string `json:"siteId"`
+	Website    string `json:"website"`
+	Field      string `json:"field"`
+	Metabase   string `json:"metabase"`
+	Salesforce string `json:"salesforce"`
+}
+
+// Orphan is an account that only one of the systems knows about
+type Orphan struct {
+	SiteId  string  `json:"siteId"`
+	Website string  `json:"website"`
+	Manager string  `json:"csm"`
+	MRR     float64 `json:"mrr"`
+}
+
+type AuditReport struct {
+	MRRMismatches      []Mismatch `json:"mrrMismatches"`
+	CSMMismatches      []Mismatch `json:"csmMismatches"`
+	PlatformMismatches []Mismatch `json:"platformMismatches"`
+	MetabaseOrphans    []Orphan   `json:"metabaseOrphans"`
+	SalesforceOrphans  []Orphan   `json:"salesforceOrphans"`
+}
+
+// Audit pulls every active website and every Salesforce customer, pairs them by site
+// id or website the same way searches do, and reports the differences
+func (s *AuditServiceImpl) Audit() (*AuditReport, error) {
+	if s.MetabaseDAO == nil || s.SalesforceDAO == nil {
+		return nil, fmt.Errorf("audit needs both Metabase and Salesforce credentials")
+	}
+	metabaseData, err := s.MetabaseDAO.QueryAccounts()
+	if err != nil {
+		return nil, err
+	}
+	salesforceData, err := s.SalesforceDAO.QueryCustomers()
+	if err != nil {
+		return nil, err
+	}
+	return compareAccounts(metabaseData, salesforceData), nil
+}
+
+func compareAccounts(metabaseData []*models.AccountInfo, salesforceData []*models.AccountInfo) *AuditReport {
+	report := &AuditReport{
+		MRRMismatches:      []Mismatch{},
+		CSMMismatches:      []Mismatch{},
+		PlatformMismatches: []Mismatch{},
+		MetabaseOrphans:    []Orphan{},
+		SalesforceOrphans:  []Orphan{},
+	}
+	matched := map[int]bool{}
+	for _, mb := range metabaseData {
+		e, i := exists(mb.SiteId, mb.Website, salesforceData)
+		if !e {
+			report.MetabaseOrphans = append(report.MetabaseOrphans, toOrphan(mb))
+			continue
+		}
+		matched[i] = true
+		sf := salesforceData[i]
+		if math.Abs(mb.MRR-sf.MRR) >= 0.01 {
+			report.MRRMismatches = append(report.MRRMismatches, mismatch(mb, "MRR", formatMRR(mb.MRR), formatMRR(sf.MRR)))
+		}
+		if !strings.EqualFold(mb.Manager, sf.Manager) {
+			report.CSMMismatches = append(report.CSMMismatches, mismatch(mb, "CSM", mb.Manager, sf.Manager))
+		}
+		if !strings.EqualFold(mb.Platform, sf.Platform) {
+			report.PlatformMismatches = append(report.PlatformMismatches, mismatch(mb, "Platform", mb.Platform, sf.Platform))
+		}
+	}
+	for i, sf := range salesforceData {
+		if !matched[i] {
+			report.SalesforceOrphans = append(report.SalesforceOrphans, toOrphan(sf))
+		}
+	}
+	return report
+}
+
+func mismatch(account *models.AccountInfo, field string, metabaseValue string, salesforceValue string) Mismatch {
+	return Mismatch{
+		SiteId:     account.SiteId,
+		Website:    account.Website,
+		Field:      field,
+		Metabase:   metabaseValue,
+		Salesforce: salesforceValue,
+	}
+}
+
+func toOrphan(account *models.AccountInfo) Orphan {
+	return Orphan{
+		SiteId:  account.SiteId,
+		Website: account.Website,
+		Manager: account.Manager,
+		MRR:     account.MRR,
+	}
+}
+
+func formatMRR(mrr float64) string {
+	if mrr < 0 {
+		return "unknown"
+	}
+	return strconv.FormatFloat(mrr, 'f', 2, 64)
+}
+
+// auditSampleSize is how many entries of each kind are listed in Slack
+const auditSampleSize = 10
+
+// FormatAuditReport summarises the report as a Slack message, the full report is available from the audit endpoint
+func FormatAuditReport(report *AuditReport, reportURL string) *slack.Msg {
+	p := message.NewPrinter(language.English)
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         "Salesforce vs Metabase audit, full report: " + reportURL,
+		Attachments:  []slack.Attachment{},
+	}
+	addMismatches := func(title string, mismatches []Mismatch) {
+		lines := []string{}
+		for i, m := range mismatches {
+			if i == auditSampleSize {
+				lines = append(lines, p.Sprintf("...and %d more", len(mismatches)-auditSampleSize))
+				break
+			}
+			lines = append(lines, m.Website+" ("+m.SiteId+"): Metabase "+m.Metabase+" / Salesforce "+m.Salesforce)
+		}
+		msg.Attachments = append(msg.Attachments, slack.Attachment{
+			Color:      "#3A23AD",
+			AuthorName: p.Sprintf("%s (%d)", title, len(mismatches)),
+			Text:       strings.Join(lines, "\n"),
+		})
+	}
+	addOrphans := func(title string, orphans []Orphan) {
+		lines := []string{}
+		for i, o := range orphans {
+			if i == auditSampleSize {
+				lines = append(lines, p.Sprintf("...and %d more", len(orphans)-auditSampleSize))
+				break
+			}
+			lines = append(lines, o.Website+" (SiteId: "+o.SiteId+", Rep: "+o.Manager+")")
+		}
+		msg.Attachments = append(msg.Attachments, slack.Attachment{
+			Color:      "#FF0000",
+			AuthorName: p.Sprintf("%s (%d)", title, len(orphans)),
+			Text:       strings.Join(lines, "\n"),
+		})
+	}
+	addMismatches("MRR mismatches", report.MRRMismatches)
+	addMismatches("CSM mismatches", report.CSMMismatches)
+	addMismatches("Platform mismatches", report.PlatformMismatches)
+	addOrphans("Websites with no Salesforce account", report.MetabaseOrphans)
+	addOrphans("Salesforce customers with no website", report.SalesforceOrphans)
+	return msg
+}
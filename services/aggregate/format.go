@@ -0,0 +1,38 @@
+package aggregate
+
+import (
+	"strings"
+
+	"golang.org/x/text/message"
+)
+
+// formatting shared by the migration and profile cards
+
+// formatDollarMRR shows an MRR in dollars for Slack, negative MRRs are unknown
+func formatDollarMRR(p *message.Printer, mrr float64) string {
+	if mrr < 0 {
+		return "unknown"
+	}
+	return p.Sprintf("$%.2f", mrr)
+}
+
+func joinOrNone(lines []string) string {
+	if len(lines) == 0 {
+		return "none"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+func orUnknown(value string) string {
+	if value == "" || strings.EqualFold(value, "unknown") {
+		return "unknown"
+	}
+	return value
+}
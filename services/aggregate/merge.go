@@ -0,0 +1,129 @@
+package aggregate
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/searchspring/nebo/models"
+)
+
+// sourceRecords are the records one source returned for a search
+type sourceRecords struct {
+	source   string
+	accounts []*models.AccountInfo
+}
+
+// accountGroup is every source's record for a single account
+type accountGroup struct {
+	records []*models.AccountInfo
+	sources []string
+}
+
+func (g *accountGroup) record(source string) *models.AccountInfo {
+	for i, name := range g.sources {
+		if name == source {
+			return g.records[i]
+		}
+	}
+	return nil
+}
+
+// mergeAccounts combines the records from every source into one record per account.
+// Records describe the same account when they share a site id or website. Results
+// must be ordered by source priority. Each field is taken from the highest priority
+// source that knows its value, fieldPriority can name a different order of sources
+// for individual AccountInfo fields. The source of every field is recorded in
+// Sources. Accounts that any source knows to be something other than a customer are
+// left out.
+func mergeAccounts(results []sourceRecords, fieldPriority map[string][]string) []*models.AccountInfo {
+	groups := []*accountGroup{}
+	for _, result := range results {
+		for _, account := range result.accounts {
+			group := findGroup(account, groups)
+			if group == nil {
+				group = &accountGroup{}
+				groups = append(groups, group)
+			} else if group.record(result.source) != nil {
+				continue
+			}
+			group.records = append(group.records, account)
+			group.sources = append(group.sources, result.source)
+		}
+	}
+
+	merged := []*models.AccountInfo{}
+	for _, group := range groups {
+		if !isCustomerGroup(group) {
+			continue
+		}
+		account := *group.records[0]
+		account.Sources = map[string]string{}
+		for _, field := range models.MergeFields {
+			source := pickSource(group, field, fieldPriority[field])
+			copyField(&account, group.record(source), field)
+			account.Sources[field] = source
+		}
+		for _, field := range models.LinkFields {
+			copyField(&account, group.record(pickSource(group, field, nil)), field)
+		}
+		merged = append(merged, &account)
+	}
+	return merged
+}
+
+// pickSource returns the first source in preference order that knows the value of
+// the field, falling back to the highest priority source when none of them do
+func pickSource(group *accountGroup, field string, preferred []string) string {
+	candidates := append(append([]string{}, preferred...), group.sources...)
+	for _, source := range candidates {
+		if record := group.record(source); record != nil && isKnown(record, field) {
+			return source
+		}
+	}
+	return group.sources[0]
+}
+
+func findGroup(account *models.AccountInfo, groups []*accountGroup) *accountGroup {
+	for _, group := range groups {
+		if e, _ := exists(account.SiteId, account.Website, group.records); e {
+			return group
+		}
+	}
+	return nil
+}
+
+// isCustomerGroup is false when a source that tracks account types, like Salesforce,
+// says the account is not a customer
+func isCustomerGroup(group *accountGroup) bool {
+	for _, record := range group.records {
+		if record.Type != "" && record.Type != "Customer" && record.Type != "Inactive Customer" {
+			return false
+		}
+	}
+	return true
+}
+
+// isKnown is false for the placeholders the DAOs use when a value is missing
+func isKnown(account *models.AccountInfo, field string) bool {
+	value := reflect.ValueOf(account).Elem().FieldByName(field)
+	switch value.Kind() {
+	case reflect.String:
+		v := value.String()
+		return v != "" && !strings.EqualFold(v, "unknown")
+	case reflect.Float64:
+		return value.Float() > 0
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.Struct:
+		return !value.IsZero()
+	}
+	return false
+}
+
+func copyField(dst *models.AccountInfo, src *models.AccountInfo, field string) {
+	to := reflect.ValueOf(dst).Elem().FieldByName(field)
+	if !to.IsValid() {
+		return
+	}
+	to.Set(reflect.ValueOf(src).Elem().FieldByName(field))
+}
@@ -0,0 +1,241 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/dals/nextopia"
+	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/domains"
+	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// MigrationCommand is the /nebo sub command that looks up a Nextopia customer's migration
+const MigrationCommand = "migration"
+
+// maxMigrationDomains is how many domains a lookup shows, each is a card
+const maxMigrationDomains = 10
+
+// MigrationService correlates Nextopia accounts with Searchspring websites and
+// Salesforce accounts by domain
+type MigrationService interface {
+	Lookup(ctx context.Context, query string) ([]*Migration, error)
+}
+
+type MigrationServiceImpl struct {
+	MetabaseDAO   metabase.DAO
+	NextopiaDAO   nextopia.DAO
+	SalesforceDAO salesforce.DAO
+}
+
+// Migration is what each side knows about a domain, Salesforce is left out when
+// there are no Salesforce credentials. Nextopia accounts without a URL have no domain
+// and can't be matched.
+type Migration struct {
+	Domain     string
+	Nextopia   []*nextopia.NextopiaAccount
+	Websites   []*models.AccountInfo
+	Salesforce []*models.AccountInfo
+}
+
+// NextopiaActive reports whether any Nextopia account for the domain is active
+func (m *Migration) NextopiaActive() bool {
+	for _, account := range m.Nextopia {
+		if account.Active() {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchspringActive reports whether the domain has an active website or a Salesforce customer
+func (m *Migration) SearchspringActive() bool {
+	for _, website := range m.Websites {
+		if website.Active == "Active" {
+			return true
+		}
+	}
+	for _, account := range m.Salesforce {
+		if account.Type == "Customer" {
+			return true
+		}
+	}
+	return false
+}
+
+// Status summarises the migration, customers active on both or neither are flagged
+func (m *Migration) Status() string {
+	nx, ss := m.NextopiaActive(), m.SearchspringActive()
+	switch {
+	case nx && ss:
+		return ":warning: Active on both Nextopia and Searchspring"
+	case !nx && !ss:
+		return ":warning: Active on neither Nextopia nor Searchspring"
+	case ss && len(m.Nextopia) == 0:
+		return "Searchspring only, not in Nextopia"
+	case ss:
+		return ":white_check_mark: Migrated to Searchspring"
+	}
+	return "Not migrated, still on Nextopia"
+}
+
+// Lookup finds the migrations for a domain or a Nextopia id (or id prefix), one per
+// domain of the matching Nextopia accounts
+func (s *MigrationServiceImpl) Lookup(ctx context.Context, query string) ([]*Migration, error) {
+	if s.NextopiaDAO == nil || s.MetabaseDAO == nil {
+		return nil, errors.New("migration lookups need both Nextopia and Metabase credentials")
+	}
+	query = strings.TrimSpace(query)
+	accounts, err := s.NextopiaDAO.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []*Migration{}
+	byDomain := map[string]*Migration{}
+	add := func(domain string) *Migration {
+		if m, ok := byDomain[domain]; ok {
+			return m
+		}
+		m := &Migration{Domain: domain}
+		byDomain[domain] = m
+		migrations = append(migrations, m)
+		return m
+	}
+	domain := domains.Canonical(query)
+	for _, account := range accounts {
+		host := domains.Canonical(account.URL)
+		if !nextopiaIDMatch(account, query) && (host == "" || host != domain) {
+			continue
+		}
+		m := add(host)
+		m.Nextopia = append(m.Nextopia, account)
+	}
+	if len(migrations) == 0 && domains.IsDomain(query) {
+		add(domain)
+	}
+	if len(migrations) > maxMigrationDomains {
+		migrations = migrations[:maxMigrationDomains]
+	}
+
+	for _, m := range migrations {
+		if err := s.addSearchspring(ctx, m); err != nil {
+			return nil, err
+		}
+	}
+	return migrations, nil
+}
+
+func nextopiaIDMatch(account *nextopia.NextopiaAccount, query string) bool {
+	query = strings.ToLower(query)
+	return query != "" && (strings.HasPrefix(strings.ToLower(account.ID1), query) || strings.HasPrefix(strings.ToLower(account.ID2), query))
+}
+
+// addSearchspring adds the active and inactive websites and the Salesforce accounts for the domain
+func (s *MigrationServiceImpl) addSearchspring(ctx context.Context, m *Migration) error {
+	if m.Domain == "" {
+		return nil
+	}
+	website := search.Condition{Field: search.Website, Op: search.Matches, Text: m.Domain}
+	for _, active := range []bool{true, false} {
+		websites, err := s.MetabaseDAO.Search(ctx, &search.Query{Conditions: []search.Condition{
+			website,
+			{Field: search.Active, Op: search.Matches, Bool: active},
+		}})
+		if err != nil {
+			return err
+		}
+		m.Websites = append(m.Websites, sameDomain(websites, m.Domain)...)
+	}
+	if s.SalesforceDAO == nil {
+		return nil
+	}
+	accounts, err := s.SalesforceDAO.Search(ctx, &search.Query{Conditions: []search.Condition{website}})
+	if err != nil {
+		return err
+	}
+	m.Salesforce = sameDomain(accounts, m.Domain)
+	return nil
+}
+
+// sameDomain keeps the accounts whose canonical host is the domain, the searches
+// match part of the website
+func sameDomain(accounts []*models.AccountInfo, domain string) []*models.AccountInfo {
+	same := []*models.AccountInfo{}
+	for _, account := range accounts {
+		if domains.Same(account.Website, domain) {
+			same = append(same, account)
+		}
+	}
+	sort.SliceStable(same, func(i, j int) bool {
+		return same[i].MRR > same[j].MRR
+	})
+	return same
+}
+
+// FormatMigrations shows each domain as a card with Nextopia, the websites table and
+// Salesforce side by side
+func FormatMigrations(migrations []*Migration, query string) *slack.Msg {
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         "Migration status for: " + query,
+	}
+	if len(migrations) == 0 {
+		msg.Text = "No Nextopia account or website for: " + query
+		return msg
+	}
+	cards := []common.Card{}
+	for _, m := range migrations {
+		cards = append(cards, migrationCard(m))
+	}
+	common.SetCards(msg, cards)
+	return msg
+}
+
+func migrationCard(m *Migration) common.Card {
+	p := message.NewPrinter(language.English)
+	nextopiaLines := []string{}
+	for _, account := range m.Nextopia {
+		nextopiaLines = append(nextopiaLines, account.Status+", "+account.Plan+"\nID: "+account.ID1)
+	}
+	websiteLines := []string{}
+	siteID := ""
+	for _, website := range m.Websites {
+		if siteID == "" {
+			siteID = website.SiteId
+		}
+		websiteLines = append(websiteLines, website.Active+", "+website.SiteId+"\nMRR: "+formatDollarMRR(p, website.MRR))
+	}
+	salesforceLines := []string{}
+	for _, account := range m.Salesforce {
+		salesforceLines = append(salesforceLines, orNone(account.Type)+"\nMRR: "+formatDollarMRR(p, account.MRR))
+	}
+	title := "*" + m.Domain + "*"
+	if m.Domain == "" {
+		title = "*No URL in Nextopia*"
+	}
+	card := common.Card{
+		Title: title,
+		Fields: []common.CardField{
+			{Label: "Nextopia", Value: joinOrNone(nextopiaLines)},
+			{Label: "Searchspring", Value: joinOrNone(websiteLines)},
+			{Label: "Salesforce", Value: joinOrNone(salesforceLines)},
+		},
+		Context: []string{m.Status()},
+	}
+	if siteID != "" {
+		card.Buttons = append(card.Buttons, common.CopySiteIDButton(siteID))
+	}
+	if m.Domain != "" {
+		card.Buttons = append(card.Buttons, common.OpenWebsiteButton(m.Domain))
+	}
+	return card
+}
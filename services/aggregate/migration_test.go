@@ -0,0 +1,94 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/searchspring/nebo/dals/nextopia"
+	"github.com/searchspring/nebo/mocks"
+	"github.com/searchspring/nebo/models"
+	"github.com/stretchr/testify/require"
+)
+
+func migrationService() *MigrationServiceImpl {
+	return &MigrationServiceImpl{
+		NextopiaDAO: &mocks.NextopiaDAO{Customers: []*nextopia.NextopiaAccount{
+			{ID1: "ee33869e", ID2: "6130a8c8", Name: "ec_123djcom", Status: "INACTIVE", URL: "http://www.123dj.com/", Plan: "Professional"},
+			{ID1: "c3f3888a", ID2: "cec4a2a2", Name: "ec_123securityproductscom", Status: "ACTIVE", URL: "123securityproducts.com", Plan: "Professional"},
+			{ID1: "00b5a608", ID2: "b913c134", Name: "ec_101inkscom", Status: "INACTIVE", URL: "101inks.com", Plan: "Trial"},
+			{ID1: "a21bcde5", ID2: "7213b73f", Name: "ec_nourl", Status: "ACTIVE", Plan: "Trial"},
+		}},
+		MetabaseDAO: &mocks.MetabaseDAO{Accounts: []*models.AccountInfo{
+			{SiteId: "abc123", Website: "123dj.com", Active: "Active", MRR: 1200},
+			{SiteId: "def456", Website: "www.123securityproducts.com", Active: "Active", MRR: 300},
+			{SiteId: "ghi789", Website: "101inks.com", Active: "Not active", MRR: 0},
+			{SiteId: "jkl012", Website: "shop.123dj.com", Active: "Active", MRR: 50},
+		}},
+		SalesforceDAO: &mocks.SalesforceDAO{Accounts: []*models.AccountInfo{
+			{Type: "Customer", Website: "https://123dj.com", MRR: 1250},
+			{Type: "Former Customer", Website: "101inks.com", MRR: -1},
+		}},
+	}
+}
+
+func TestMigrationLookupByDomain(t *testing.T) {
+	migrations, err := migrationService().Lookup(context.Background(), "WWW.123dj.com")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(migrations))
+	m := migrations[0]
+	require.Equal(t, "123dj.com", m.Domain)
+	require.Equal(t, "ec_123djcom", m.Nextopia[0].Name)
+	require.Equal(t, 1, len(m.Websites))
+	require.Equal(t, "abc123", m.Websites[0].SiteId)
+	require.Equal(t, 1, len(m.Salesforce))
+	require.Equal(t, ":white_check_mark: Migrated to Searchspring", m.Status())
+}
+
+func TestMigrationLookupFlags(t *testing.T) {
+	service := migrationService()
+
+	migrations, err := service.Lookup(context.Background(), "c3f3")
+	require.NoError(t, err)
+	require.Equal(t, "123securityproducts.com", migrations[0].Domain)
+	require.Equal(t, ":warning: Active on both Nextopia and Searchspring", migrations[0].Status())
+
+	migrations, err = service.Lookup(context.Background(), "101inks.com")
+	require.NoError(t, err)
+	require.Equal(t, "Not active", migrations[0].Websites[0].Active)
+	require.Equal(t, ":warning: Active on neither Nextopia nor Searchspring", migrations[0].Status())
+
+	migrations, err = service.Lookup(context.Background(), "a21bcde5")
+	require.NoError(t, err)
+	require.Equal(t, "", migrations[0].Domain)
+	require.Empty(t, migrations[0].Websites)
+	require.Equal(t, "Not migrated, still on Nextopia", migrations[0].Status())
+
+	migrations, err = service.Lookup(context.Background(), "shop.123dj.com")
+	require.NoError(t, err)
+	require.Empty(t, migrations[0].Nextopia)
+	require.Equal(t, "Searchspring only, not in Nextopia", migrations[0].Status())
+
+	migrations, err = service.Lookup(context.Background(), "nothing")
+	require.NoError(t, err)
+	require.Empty(t, migrations)
+	require.Equal(t, "No Nextopia account or website for: nothing", FormatMigrations(migrations, "nothing").Text)
+}
+
+func TestMigrationLookupNeedsCredentials(t *testing.T) {
+	_, err := (&MigrationServiceImpl{MetabaseDAO: &mocks.MetabaseDAO{}}).Lookup(context.Background(), "123dj.com")
+	require.EqualError(t, err, "migration lookups need both Nextopia and Metabase credentials")
+}
+
+func TestFormatMigrations(t *testing.T) {
+	migrations, err := migrationService().Lookup(context.Background(), "123dj.com")
+	require.NoError(t, err)
+	msg := FormatMigrations(migrations, "123dj.com")
+	require.Equal(t, "Migration status for: 123dj.com", msg.Text)
+	data, err := json.Marshal(msg.Blocks)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "*Nextopia*\\nINACTIVE, Professional\\nID: ee33869e")
+	require.Contains(t, string(data), "*Searchspring*\\nActive, abc123\\nMRR: $1,200.00")
+	require.Contains(t, string(data), "*Salesforce*\\nCustomer\\nMRR: $1,250.00")
+	require.Contains(t, string(data), "Migrated to Searchspring")
+}
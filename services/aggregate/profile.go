@@ -0,0 +1,214 @@
+package aggregate
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/domains"
+	"github.com/searchspring/nebo/models"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// ShowCommand is the /nebo sub command that shows the full profile of one account
+const ShowCommand = "show"
+
+// maxProfiles is how many accounts a lookup shows, a site id or domain is rarely shared
+const maxProfiles = 5
+
+// ProfileService looks up everything the websites table and Salesforce know about an account
+type ProfileService interface {
+	Show(key string) ([]*Profile, error)
+}
+
+type ProfileServiceImpl struct {
+	MetabaseDAO   metabase.DAO
+	SalesforceDAO salesforce.DAO
+}
+
+// Profile is an account's website and its Salesforce account, either can be missing
+type Profile struct {
+	Website    *models.AccountInfo
+	Salesforce *models.AccountInfo
+}
+
+// Account merges the website and the Salesforce account, fields the websites table
+// knows come from it and the rest from Salesforce
+func (p *Profile) Account() *models.AccountInfo {
+	records := []*models.AccountInfo{}
+	for _, record := range []*models.AccountInfo{p.Website, p.Salesforce} {
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	account := *records[0]
+	fields := append(append(append([]string{}, models.MergeFields...), models.LinkFields...), models.ProfileFields...)
+	for _, record := range records[1:] {
+		for _, field := range fields {
+			if !isKnown(&account, field) && isKnown(record, field) {
+				copyField(&account, record, field)
+			}
+		}
+	}
+	return &account
+}
+
+// Show finds the accounts with the site id or website key, pairing each website with
+// the Salesforce account for the same site id or host. Unlike a search it finds
+// inactive, presales and sandbox sites and accounts of any type.
+func (s *ProfileServiceImpl) Show(key string) ([]*Profile, error) {
+	if s.MetabaseDAO == nil && s.SalesforceDAO == nil {
+		return nil, errors.New("showing an account needs Metabase or Salesforce credentials")
+	}
+	key = strings.TrimSpace(key)
+	profiles := []*Profile{}
+	if s.MetabaseDAO != nil {
+		websites, err := s.MetabaseDAO.Profile(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, website := range exactMatches(websites, key) {
+			profiles = append(profiles, &Profile{Website: website})
+		}
+	}
+	if s.SalesforceDAO != nil {
+		accounts, err := s.SalesforceDAO.Profile(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range exactMatches(accounts, key) {
+			if profile := findProfile(account, profiles); profile != nil {
+				profile.Salesforce = account
+				continue
+			}
+			profiles = append(profiles, &Profile{Salesforce: account})
+		}
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Account().MRR > profiles[j].Account().MRR
+	})
+	if len(profiles) > maxProfiles {
+		profiles = profiles[:maxProfiles]
+	}
+	return profiles, nil
+}
+
+// exactMatches keeps the accounts with the site id or the same host as key, the DAOs
+// match part of the website
+func exactMatches(accounts []*models.AccountInfo, key string) []*models.AccountInfo {
+	matches := []*models.AccountInfo{}
+	for _, account := range accounts {
+		if strings.EqualFold(account.SiteId, key) || domains.Same(account.Website, key) {
+			matches = append(matches, account)
+		}
+	}
+	return matches
+}
+
+// findProfile returns the profile whose website is the Salesforce account's and
+// doesn't have a Salesforce account yet
+func findProfile(account *models.AccountInfo, profiles []*Profile) *Profile {
+	for _, profile := range profiles {
+		if profile.Website == nil || profile.Salesforce != nil {
+			continue
+		}
+		if found, _ := exists(account.SiteId, account.Website, []*models.AccountInfo{profile.Website}); found {
+			return profile
+		}
+	}
+	return nil
+}
+
+// FormatProfiles renders each account as a detailed card, links are where the
+// buttons go
+func FormatProfiles(profiles []*Profile, key string, links common.AccountLinks) *slack.Msg {
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         "Account profile for: " + key,
+	}
+	if len(profiles) == 0 {
+		msg.Text = "No website or Salesforce account for: " + key
+		return msg
+	}
+	blocks := []slack.Block{}
+	for i, profile := range profiles {
+		if i > 0 {
+			blocks = append(blocks, slack.NewDividerBlock())
+		}
+		for _, card := range profileCards(profile, links) {
+			blocks = append(blocks, card.Blocks()...)
+		}
+	}
+	msg.Blocks = slack.Blocks{BlockSet: blocks}
+	return msg
+}
+
+// profileCards splits the profile over two cards as Slack shows at most ten fields in a
+// section, the account first and then its revenue and billing
+func profileCards(profile *Profile, links common.AccountLinks) []common.Card {
+	p := message.NewPrinter(language.English)
+	account := profile.Account()
+	title := "*" + common.EscapeText(orUnknown(account.Website)) + "* (" + orUnknown(account.Active) + ")"
+	if isKnown(account, "Website") {
+		title = "*" + common.Link(common.WebsiteURL(account.Website), account.Website) + "* (" + orUnknown(account.Active) + ")"
+	}
+	created := "unknown"
+	if !account.Created.IsZero() {
+		created = account.Created.Format("Jan 2, 2006")
+	}
+	details := common.Card{
+		Title: title,
+		Fields: []common.CardField{
+			{Label: "Account type", Value: orUnknown(account.Type)},
+			{Label: "Rep", Value: orUnknown(account.Manager)},
+			{Label: "Account owner", Value: orUnknown(account.Owner)},
+			{Label: "Created", Value: created},
+			{Label: "Parent account", Value: orNone(account.Parent)},
+			{Label: "Platform", Value: orUnknown(account.Platform)},
+			{Label: "Integration", Value: orUnknown(account.Integration)},
+			{Label: "Provider", Value: orUnknown(account.Provider)},
+		},
+		Context: []string{"SiteId: `" + orUnknown(account.SiteId) + "`", "Found in: " + profileSources(profile)},
+	}
+	if account.Presales {
+		details.Context = append(details.Context, ":warning: Presales site")
+	}
+	if account.Sandbox {
+		details.Context = append(details.Context, ":warning: Sandbox site")
+	}
+	location := []string{}
+	if isKnown(account, "City") {
+		location = append(location, account.City)
+	}
+	if isKnown(account, "State") {
+		location = append(location, account.State)
+	}
+	billing := common.Card{
+		Title: "*Revenue and billing*",
+		Fields: []common.CardField{
+			{Label: "MRR", Value: formatDollarMRR(p, account.MRR)},
+			{Label: "Family MRR", Value: formatDollarMRR(p, account.FamilyMRR)},
+			{Label: "Billing location", Value: orUnknown(strings.Join(location, ", "))},
+			{Label: "Billing country", Value: orUnknown(account.Country)},
+		},
+		// the account card has the same buttons
+		Buttons: common.AccountCard(account, account.FamilyMRR, common.CardOptions{Links: links}).Buttons,
+	}
+	return []common.Card{details, billing}
+}
+
+func profileSources(profile *Profile) string {
+	sources := []string{}
+	if profile.Website != nil {
+		sources = append(sources, MetabaseSource)
+	}
+	if profile.Salesforce != nil {
+		sources = append(sources, SalesforceSource)
+	}
+	return strings.Join(sources, ", ")
+}
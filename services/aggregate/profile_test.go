@@ -0,0 +1,99 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/mocks"
+	"github.com/searchspring/nebo/models"
+	"github.com/stretchr/testify/require"
+)
+
+func profileService() *ProfileServiceImpl {
+	return &ProfileServiceImpl{
+		MetabaseDAO: &mocks.MetabaseDAO{Accounts: []*models.AccountInfo{
+			{SiteId: "abc123", Website: "shoes.com", Active: "Active", Manager: "Jane Doe", MRR: 1200, Platform: "Shopify", City: "Denver", State: "CO", WebsiteId: "42", Sandbox: true},
+			{SiteId: "def456", Website: "shoestore.com", Active: "Active", MRR: 300},
+		}},
+		SalesforceDAO: &mocks.SalesforceDAO{Accounts: []*models.AccountInfo{
+			{SiteId: "unknown", Type: "Customer", Website: "https://www.shoes.com", Manager: "unknown", MRR: 1250, FamilyMRR: 4000, Platform: "Magento",
+				SalesforceId: "001abc", Country: "United States", Owner: "Sam Seller", Parent: "Shoe Group", Created: time.Date(2019, 3, 4, 0, 0, 0, 0, time.UTC)},
+			{SiteId: "ghi789", Type: "Prospect", Website: "boots.com", MRR: -1},
+		}},
+	}
+}
+
+func TestShowPairsWebsiteAndSalesforce(t *testing.T) {
+	profiles, err := profileService().Show("https://shoes.com/")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(profiles))
+	require.Equal(t, "abc123", profiles[0].Website.SiteId)
+	require.Equal(t, "001abc", profiles[0].Salesforce.SalesforceId)
+
+	account := profiles[0].Account()
+	require.Equal(t, "shoes.com", account.Website)
+	require.Equal(t, "Jane Doe", account.Manager)
+	require.Equal(t, "Shopify", account.Platform)
+	require.Equal(t, float64(1200), account.MRR)
+	require.Equal(t, float64(4000), account.FamilyMRR)
+	require.Equal(t, "Customer", account.Type)
+	require.Equal(t, "Sam Seller", account.Owner)
+	require.Equal(t, "United States", account.Country)
+	require.True(t, account.Sandbox)
+	require.Equal(t, "42", account.WebsiteId)
+	require.Equal(t, "001abc", account.SalesforceId)
+}
+
+func TestShowBySiteID(t *testing.T) {
+	service := profileService()
+
+	profiles, err := service.Show("DEF456")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(profiles))
+	require.Nil(t, profiles[0].Salesforce)
+
+	// accounts only in Salesforce are shown whatever their type
+	profiles, err = service.Show("ghi789")
+	require.NoError(t, err)
+	require.Nil(t, profiles[0].Website)
+	require.Equal(t, "Prospect", profiles[0].Account().Type)
+
+	profiles, err = service.Show("shoes")
+	require.NoError(t, err)
+	require.Empty(t, profiles)
+}
+
+func TestShowWithoutSalesforce(t *testing.T) {
+	service := profileService()
+	service.SalesforceDAO = nil
+	profiles, err := service.Show("shoes.com")
+	require.NoError(t, err)
+	require.Nil(t, profiles[0].Salesforce)
+
+	_, err = (&ProfileServiceImpl{}).Show("shoes.com")
+	require.Error(t, err)
+}
+
+func TestFormatProfiles(t *testing.T) {
+	profiles, _ := profileService().Show("shoes.com")
+	msg := FormatProfiles(profiles, "shoes.com", common.AccountLinks{SalesforceURL: "https://sf.example.com/"})
+	require.Equal(t, "Account profile for: shoes.com", msg.Text)
+	b, err := json.Marshal(msg)
+	require.NoError(t, err)
+	body := string(b)
+	for _, expected := range []string{"Sam Seller", "Shoe Group", "Mar 4, 2019", "United States", "Denver, CO", "$1,200.00", "$4,000.00", "Sandbox site", "Found in: Metabase, Salesforce", "https://sf.example.com/001abc"} {
+		require.Contains(t, body, expected)
+	}
+	require.NotContains(t, body, "Presales site")
+
+	msg = FormatProfiles(nil, "nothing.com", common.AccountLinks{})
+	require.Equal(t, "No website or Salesforce account for: nothing.com", msg.Text)
+	require.Empty(t, msg.Blocks.BlockSet)
+}
+
+func TestProfileCardsEscapeWebsite(t *testing.T) {
+	cards := profileCards(&Profile{Website: &models.AccountInfo{Website: "shoes.com/<a>|b", Active: "Active"}}, common.AccountLinks{})
+	require.Equal(t, "*<https://shoes.com/&lt;a&gt;%7Cb|shoes.com/&lt;a&gt;b>* (Active)", cards[0].Title)
+}
@@ -0,0 +1,90 @@
+package aggregate
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/searchspring/nebo/domains"
+	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
+)
+
+// relevance tiers, higher is better. Accounts that only matched on another field, such
+// as the platform, or only on conditions are an otherMatch.
+const (
+	otherMatch = iota
+	typoMatch
+	substringMatch
+	prefixMatch
+	nameMatch
+	domainMatch
+	siteIDMatch
+)
+
+// relevance is how well the account matches the free text of a search: the site id,
+// then the same website host, the same domain name (shoes for shoes.com), a host or name
+// that starts with the text, contains it or is a typo away from it
+func relevance(account *models.AccountInfo, text string) int {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return otherMatch
+	}
+	if strings.EqualFold(account.SiteId, text) {
+		return siteIDMatch
+	}
+	host := domains.Canonical(account.Website)
+	if host == "" {
+		return otherMatch
+	}
+	if domains.Same(account.Website, text) {
+		return domainMatch
+	}
+	term := strings.ToLower(domains.SearchTerm(text))
+	name := domains.Name(account.Website)
+	switch {
+	case name == term:
+		return nameMatch
+	case strings.HasPrefix(host, term), strings.HasPrefix(name, term):
+		return prefixMatch
+	case strings.Contains(host, term):
+		return substringMatch
+	case domains.Score(text, account.Website) >= domains.MinScore:
+		return typoMatch
+	}
+	return otherMatch
+}
+
+// rankAccounts orders the accounts for the search: by relevance with the highest MRR
+// first among equally relevant accounts, by MRR alone or by website
+func rankAccounts(accounts []*models.AccountInfo, query *search.Query) []*models.AccountInfo {
+	byWebsite := func(i, j int) bool {
+		return domains.Canonical(accounts[i].Website) < domains.Canonical(accounts[j].Website)
+	}
+	switch query.Sort {
+	case search.ByName:
+		sort.SliceStable(accounts, byWebsite)
+	case search.ByMRR:
+		sort.SliceStable(accounts, func(i, j int) bool {
+			if accounts[i].MRR != accounts[j].MRR {
+				return accounts[i].MRR > accounts[j].MRR
+			}
+			return byWebsite(i, j)
+		})
+	default:
+		scores := map[*models.AccountInfo]int{}
+		for _, account := range accounts {
+			scores[account] = relevance(account, query.Text)
+		}
+		sort.SliceStable(accounts, func(i, j int) bool {
+			a, b := scores[accounts[i]], scores[accounts[j]]
+			if a != b {
+				return a > b
+			}
+			if accounts[i].MRR != accounts[j].MRR {
+				return accounts[i].MRR > accounts[j].MRR
+			}
+			return byWebsite(i, j)
+		})
+	}
+	return accounts
+}
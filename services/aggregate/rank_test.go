@@ -0,0 +1,83 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/searchspring/nebo/mocks"
+	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
+	"github.com/stretchr/testify/require"
+)
+
+func websites(accounts []*models.AccountInfo) []string {
+	result := []string{}
+	for _, account := range accounts {
+		result = append(result, account.Website)
+	}
+	return result
+}
+
+func TestRelevance(t *testing.T) {
+	account := &models.AccountInfo{SiteId: "abc123", Website: "https://www.Shoes.com/store"}
+	require.Equal(t, siteIDMatch, relevance(account, "ABC123"))
+	require.Equal(t, domainMatch, relevance(account, "shoes.com"))
+	require.Equal(t, nameMatch, relevance(account, "shoes"))
+	require.Equal(t, prefixMatch, relevance(account, "sho"))
+	require.Equal(t, substringMatch, relevance(account, "hoes"))
+	require.Equal(t, typoMatch, relevance(account, "shoez.com"))
+	require.Equal(t, otherMatch, relevance(account, "shopify"))
+	require.Equal(t, otherMatch, relevance(account, ""))
+	require.Equal(t, otherMatch, relevance(&models.AccountInfo{Website: "unknown"}, "shoes"))
+}
+
+func TestRankAccounts(t *testing.T) {
+	accounts := func() []*models.AccountInfo {
+		return []*models.AccountInfo{
+			{Website: "bestshoesever.com", MRR: 9000},
+			{Website: "shoesandboots.com", MRR: 5000},
+			{Website: "shoes.com", MRR: 10},
+			{Website: "shoes.net", MRR: 20},
+			{Website: "boots.com", SiteId: "shoes", MRR: 1},
+		}
+	}
+
+	ranked := rankAccounts(accounts(), &search.Query{Text: "shoes.com"})
+	require.Equal(t, []string{"shoes.com", "shoes.net", "bestshoesever.com", "shoesandboots.com", "boots.com"}, websites(ranked))
+
+	// the name matches both shoes.com and shoes.net, the higher MRR wins
+	ranked = rankAccounts(accounts(), &search.Query{Text: "shoes"})
+	require.Equal(t, []string{"boots.com", "shoes.net", "shoes.com", "shoesandboots.com", "bestshoesever.com"}, websites(ranked))
+
+	ranked = rankAccounts(accounts(), &search.Query{Text: "shoes", Sort: search.ByMRR})
+	require.Equal(t, []string{"bestshoesever.com", "shoesandboots.com", "shoes.net", "shoes.com", "boots.com"}, websites(ranked))
+
+	ranked = rankAccounts(accounts(), &search.Query{Text: "shoes", Sort: search.ByName})
+	require.Equal(t, []string{"bestshoesever.com", "boots.com", "shoes.com", "shoes.net", "shoesandboots.com"}, websites(ranked))
+
+	// without text, e.g. a platform search, the highest MRR comes first
+	ranked = rankAccounts(accounts(), &search.Query{})
+	require.Equal(t, "bestshoesever.com", ranked[0].Website)
+}
+
+func TestQueryRanksBeforePaging(t *testing.T) {
+	accounts := []*models.AccountInfo{}
+	for i := 0; i < 25; i++ {
+		accounts = append(accounts, &models.AccountInfo{SiteId: fmt.Sprintf("site%02d", i), Website: fmt.Sprintf("www.shoes%02d.com", i), MRR: float64(1000 + i)})
+	}
+	accounts = append(accounts, &models.AccountInfo{SiteId: "exact", Website: "https://shoes.com/", MRR: 1})
+	service := &AggregateServiceImpl{
+		Deps: &Deps{
+			Sources: NewRegistry(NewMetabaseSource(&mocks.MetabaseDAO{Accounts: accounts})),
+		},
+	}
+
+	msg, err := service.Query(context.Background(), "shoes.com", 0)
+	require.NoError(t, err)
+	require.Contains(t, cardTitles(msg)[0], "shoes.com")
+
+	msg, err = service.Query(context.Background(), "shoes sort:mrr", 0)
+	require.NoError(t, err)
+	require.Contains(t, cardTitles(msg)[0], "shoes24.com")
+}
@@ -0,0 +1,128 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/dals/nextopia"
+	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/models"
+	"github.com/searchspring/nebo/search"
+)
+
+// AccountSource is a system that contributes account records to a search.
+// When sources disagree, the record from the source with the higher priority wins.
+type AccountSource interface {
+	Name() string
+	Priority() int
+	Query(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error)
+}
+
+// Registry holds the account sources that are searched by the aggregate service
+type Registry struct {
+	sources []AccountSource
+}
+
+// NewRegistry returns a registry containing the given sources
+func NewRegistry(sources ...AccountSource) *Registry {
+	registry := &Registry{}
+	for _, source := range sources {
+		registry.Register(source)
+	}
+	return registry
+}
+
+// Register adds a source to the registry
+func (r *Registry) Register(source AccountSource) {
+	r.sources = append(r.sources, source)
+}
+
+// Sources returns the registered sources, highest priority first
+func (r *Registry) Sources() []AccountSource {
+	sources := append([]AccountSource{}, r.sources...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Priority() > sources[j].Priority()
+	})
+	return sources
+}
+
+var errNotConfigured = errors.New("not configured")
+
+// Source names
+const (
+	MetabaseSource   = "Metabase"
+	SalesforceSource = "Salesforce"
+	NextopiaSource   = "Nextopia"
+)
+
+// daoSource adapts a DAO query function to the AccountSource interface
+type daoSource struct {
+	name     string
+	priority int
+	query    func(context.Context, *search.Query) ([]*models.AccountInfo, error)
+}
+
+func (s *daoSource) Name() string {
+	return s.name
+}
+
+func (s *daoSource) Priority() int {
+	return s.priority
+}
+
+// Query runs the DAO query, the DAO abandons its requests when the context is done
+func (s *daoSource) Query(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error) {
+	if s.query == nil {
+		return nil, errNotConfigured
+	}
+	accounts, err := s.query(ctx, query)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return accounts, err
+}
+
+// NewMetabaseSource returns the Searchspring websites table as an account source
+func NewMetabaseSource(dao metabase.DAO) AccountSource {
+	source := &daoSource{name: MetabaseSource, priority: 20}
+	if dao != nil {
+		source.query = dao.Search
+	}
+	return source
+}
+
+// NewSalesforceSource returns Salesforce accounts as an account source
+func NewSalesforceSource(dao salesforce.DAO) AccountSource {
+	source := &daoSource{name: SalesforceSource, priority: 10}
+	if dao != nil {
+		source.query = dao.Search
+	}
+	return source
+}
+
+// NewNextopiaSource returns the Nextopia client report as an account source. The
+// report can only be searched by text so field conditions are checked in memory, like
+// Metabase only active accounts are returned unless the search has an active condition.
+func NewNextopiaSource(dao nextopia.DAO) AccountSource {
+	source := &daoSource{name: NextopiaSource, priority: 0}
+	if dao != nil {
+		source.query = func(ctx context.Context, query *search.Query) ([]*models.AccountInfo, error) {
+			// Nextopia only searches by text, without any every account would match
+			if query.Text == "" {
+				return []*models.AccountInfo{}, nil
+			}
+			accounts, err := dao.Accounts(ctx, query.Text)
+			if err != nil {
+				return nil, err
+			}
+			filter := &search.Query{Conditions: query.Conditions}
+			if _, ok := query.Condition(search.Active); !ok {
+				filter.Conditions = append([]search.Condition{{Field: search.Active, Op: search.Equal, Bool: true}}, query.Conditions...)
+			}
+			return filter.Filter(accounts), nil
+		}
+	}
+	return source
+}
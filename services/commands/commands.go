@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nlopes/slack"
+
+	"github.com/searchspring/nebo/cache"
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/metabase"
+	"github.com/searchspring/nebo/dals/nextopia"
+	"github.com/searchspring/nebo/dals/salesforce"
+	"github.com/searchspring/nebo/search"
+	"github.com/searchspring/nebo/services/aggregate"
+)
+
+// SalesforceCommand is the slash command that searches Salesforce on its own
+const SalesforceCommand = "/neboidss"
+
+// Runner produces pages of results for the paged slash commands. It is shared by the
+// slash command handler and the interactions handler behind the paging buttons.
+type Runner struct {
+	Aggregation   aggregate.AggregateService
+	MetabaseDAO   metabase.DAO
+	NextopiaDAO   nextopia.DAO
+	SalesforceDAO salesforce.DAO
+	Links         common.AccountLinks
+}
+
+// NewRunner creates the DAOs from the environment, DAOs without credentials are left nil
+func NewRunner(env common.EnvVars) *Runner {
+	responseCache := cache.Shared(env.CacheURL)
+	nextopiaDAO := nextopia.NewDAO(env.NxUser, env.NxPassword, responseCache)
+	salesForceDAO := salesforce.NewDAO(salesforce.NewCredentials(env), responseCache)
+	metabaseDAO := metabase.NewDAO("https://metabase.kube.searchspring.io/", env.MetabaseUser, env.MetabasePassword, "", responseCache)
+	links := common.NewAccountLinks(env)
+
+	return &Runner{
+		Aggregation: &aggregate.AggregateServiceImpl{
+			Deps: &aggregate.Deps{
+				Sources: aggregate.NewRegistry(
+					aggregate.NewMetabaseSource(metabaseDAO),
+					aggregate.NewSalesforceSource(salesForceDAO),
+					aggregate.NewNextopiaSource(nextopiaDAO),
+				),
+				Links: links,
+			},
+		},
+		MetabaseDAO:   metabaseDAO,
+		NextopiaDAO:   nextopiaDAO,
+		SalesforceDAO: salesForceDAO,
+		Links:         links,
+	}
+}
+
+var shared struct {
+	sync.Once
+	runner *Runner
+}
+
+// Shared returns the runner for the process so the DAOs, and their sessions, are reused
+// across requests. The DAOs log in on their first query and again when a session expires.
+func Shared(env common.EnvVars) *Runner {
+	shared.Do(func() {
+		shared.runner = NewRunner(env)
+	})
+	return shared.runner
+}
+
+// Run returns the requested page of results for the page's command
+func (r *Runner) Run(ctx context.Context, page common.Page) (*slack.Msg, error) {
+	switch page.Command {
+	case NextopiaCommand, "/neboid":
+		if r.NextopiaDAO == nil {
+			return nil, errors.New("missing required Nextopia credentials")
+		}
+		accounts, err := r.NextopiaDAO.Search(ctx, page.Text)
+		if err != nil {
+			return nil, err
+		}
+		return formatNextopiaAccounts(accounts, page), nil
+
+	case SalesforceCommand:
+		if r.SalesforceDAO == nil {
+			return nil, errors.New("missing required Salesforce credentials")
+		}
+		accounts, err := r.SalesforceDAO.Search(ctx, &search.Query{Text: page.Text})
+		if err != nil {
+			return nil, err
+		}
+		start, end := page.Bounds(len(accounts))
+		msg := common.FormatAccountBlocks(accounts[start:end], page.Text, common.CardOptions{Links: r.Links})
+		common.AddPaging(msg, page, len(accounts))
+		return msg, nil
+
+	default:
+		return r.Aggregation.Query(ctx, page.Text, page.Offset)
+	}
+}
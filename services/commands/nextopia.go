@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"github.com/nlopes/slack"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/nextopia"
+)
+
+// NextopiaCommand is the slash command that searches the Nextopia client report
+const NextopiaCommand = "/neboidnx"
+
+// lastActivityFormat is how the last activity date is shown on a card
+const lastActivityFormat = "Jan 2, 2006"
+
+// formatNextopiaAccounts renders the page of accounts as cards
+func formatNextopiaAccounts(accounts []*nextopia.NextopiaAccount, page common.Page) *slack.Msg {
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         "matches",
+	}
+	start, end := page.Bounds(len(accounts))
+	cards := []common.Card{}
+	for _, account := range accounts[start:end] {
+		cards = append(cards, nextopiaCard(account))
+	}
+	if len(cards) == 0 {
+		msg.Text = "No Matches :("
+	}
+	common.SetCards(msg, cards)
+	common.AddPaging(msg, page, len(accounts))
+	return msg
+}
+
+func nextopiaCard(account *nextopia.NextopiaAccount) common.Card {
+	lastActivity := "never"
+	if !account.LastActivity.IsZero() {
+		lastActivity = account.LastActivity.Format(lastActivityFormat)
+	}
+	card := common.Card{
+		Title: "*" + account.Name + "* (" + account.Status + ")",
+		Fields: []common.CardField{
+			{Label: "URL", Value: account.URL},
+			{Label: "Plan", Value: account.Plan},
+			{Label: "ID 1", Value: account.ID1},
+			{Label: "ID 2", Value: account.ID2},
+		},
+		Context: []string{"Version: " + account.Version, "System: " + account.System, "Last activity: " + lastActivity},
+		Buttons: []*slack.ButtonBlockElement{common.CopySiteIDButton(account.ID1)},
+	}
+	if account.URL != "" {
+		card.Buttons = append(card.Buttons, common.OpenWebsiteButton(account.URL))
+	}
+	return card
+}
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/searchspring/nebo/common"
+	"github.com/searchspring/nebo/dals/nextopia"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatNextopiaAccounts(t *testing.T) {
+	accounts := []*nextopia.NextopiaAccount{
+		{ID1: "ee33869e", ID2: "6130a8c8", Name: "ec_123djcom", Status: "ACTIVE", URL: "123dj.com", Plan: "Professional", Version: "unset", System: "v1.5.1", LastActivity: time.Date(2020, 6, 17, 18, 25, 59, 0, time.UTC)},
+		{ID1: "50ae9d89", ID2: "54762cbb", Status: "INACTIVE", Plan: "Trial"},
+	}
+	msg := formatNextopiaAccounts(accounts, common.Page{Command: NextopiaCommand, Text: "ec_"})
+	require.Equal(t, "matches", msg.Text)
+	data, err := json.Marshal(msg.Blocks)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "*ec_123djcom* (ACTIVE)")
+	require.Contains(t, string(data), "Last activity: Jun 17, 2020")
+	require.Contains(t, string(data), "Last activity: never")
+	require.Contains(t, string(data), "*Plan*\\nProfessional")
+
+	msg = formatNextopiaAccounts([]*nextopia.NextopiaAccount{}, common.Page{Command: NextopiaCommand, Text: "nothing"})
+	require.Equal(t, "No Matches :(", msg.Text)
+}